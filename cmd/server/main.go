@@ -1,16 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
 
 	"github.com/mayukhsarkar/k8s-mcp-server/pkg/api"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/auth"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/kubernetes"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/logs"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/mcp"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/policy"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port       int
-	kubeconfig string
+	port          int
+	kubeconfig    string
+	redactConfig  string
+	revealSecrets bool
+	policyFile    string
+
+	// Limits on live MCP sessions
+	maxSessions        int
+	maxSessionsPerUser int
+
+	// syslogEnterpriseID qualifies the structured data IDs of syslog exports
+	syslogEnterpriseID string
+
+	// Guard rails against modifying the cluster
+	readOnly            bool
+	protectedNamespaces []string
+	protectedResources  []string
+
+	// Authentication and TLS of the HTTP API
+	tokenAuthFile     string
+	oidcIssuerURL     string
+	oidcClientID      string
+	oidcUsernameClaim string
+	oidcGroupsClaim   string
+	oidcJWKSURL       string
+	tlsCertFile       string
+	tlsKeyFile        string
+	clientCAFile      string
+	impersonate       bool
 )
 
 func main() {
@@ -26,8 +61,46 @@ managing Kubernetes resources, retrieving and analyzing logs, and formatting log
 		Short: "Start the MCP server",
 		Long:  "Start the Kubernetes MCP server to handle requests for Kubernetes operations and log management",
 		Run: func(cmd *cobra.Command, args []string) {
+			redactor, err := newRedactor()
+			if err != nil {
+				fmt.Printf("Error configuring redaction: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := configureExport(); err != nil {
+				fmt.Printf("Error configuring log export: %v\n", err)
+				os.Exit(1)
+			}
+
+			authenticator, err := newAuthenticator()
+			if err != nil {
+				fmt.Printf("Error configuring authentication: %v\n", err)
+				os.Exit(1)
+			}
+
+			p, err := loadPolicy()
+			if err != nil {
+				fmt.Printf("Error configuring authorization: %v\n", err)
+				os.Exit(1)
+			}
+
 			fmt.Printf("Starting Kubernetes MCP Server on port %d\n", port)
-			server := api.NewServer(port, kubeconfig)
+			server := api.NewServer(api.Config{
+				Port:                port,
+				KubeconfigPath:      kubeconfig,
+				Redactor:            redactor,
+				Authenticator:       authenticator,
+				Policy:              p,
+				Impersonate:         impersonate,
+				ReadOnly:            readOnly,
+				ProtectedNamespaces: protectedNamespaces,
+				ProtectedResources:  protectedResources,
+				TLSCertFile:         tlsCertFile,
+				TLSKeyFile:          tlsKeyFile,
+				ClientCAFile:        clientCAFile,
+				MaxSessions:         maxSessions,
+				MaxSessionsPerUser:  maxSessionsPerUser,
+			})
 			if err := server.Start(); err != nil {
 				fmt.Printf("Error starting server: %v\n", err)
 				os.Exit(1)
@@ -37,11 +110,182 @@ managing Kubernetes resources, retrieving and analyzing logs, and formatting log
 
 	serveCmd.Flags().IntVarP(&port, "port", "p", 8080, "Port to run the server on")
 	serveCmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "Path to kubeconfig file (defaults to in-cluster config if empty)")
+	serveCmd.Flags().IntVar(&maxSessions, "max-sessions", api.DefaultMaxSessions, "Maximum number of live MCP sessions; new sessions are rejected with 503 beyond it")
+	serveCmd.Flags().IntVar(&maxSessionsPerUser, "max-sessions-per-user", api.DefaultMaxSessionsPerUser, "Maximum number of live MCP sessions per user, or per client address for unauthenticated callers; new sessions are rejected with 429 beyond it")
+	addRedactionFlags(serveCmd)
+	addExportFlags(serveCmd)
+	addPolicyFlags(serveCmd)
+	addGuardFlags(serveCmd)
+	serveCmd.Flags().StringVar(&tokenAuthFile, "token-auth-file", "", "Path to a CSV file of token,user,uid,\"groups\" lines accepted as bearer tokens")
+	serveCmd.Flags().StringVar(&oidcIssuerURL, "oidc-issuer-url", "", "URL of the OpenID Connect issuer whose JWTs are accepted as bearer tokens")
+	serveCmd.Flags().StringVar(&oidcClientID, "oidc-client-id", "", "Client ID that OpenID Connect tokens must be issued for")
+	serveCmd.Flags().StringVar(&oidcUsernameClaim, "oidc-username-claim", "sub", "OpenID Connect claim holding the username")
+	serveCmd.Flags().StringVar(&oidcGroupsClaim, "oidc-groups-claim", "groups", "OpenID Connect claim holding the groups")
+	serveCmd.Flags().StringVar(&oidcJWKSURL, "oidc-jwks-url", "", "URL of the OpenID Connect signing keys (defaults to the one found through discovery)")
+	serveCmd.Flags().StringVar(&tlsCertFile, "tls-cert-file", "", "Path to the TLS certificate; serves HTTPS when set")
+	serveCmd.Flags().StringVar(&tlsKeyFile, "tls-key-file", "", "Path to the TLS private key")
+	serveCmd.Flags().StringVar(&clientCAFile, "client-ca-file", "", "Path to the CA bundle verifying client certificates; their common name and organizations become the username and groups")
+	serveCmd.Flags().BoolVar(&impersonate, "impersonate", false, "Act as each authenticated caller towards the Kubernetes API using impersonation, so cluster RBAC applies per user; requires an authentication method")
+
+	stdioCmd := &cobra.Command{
+		Use:   "stdio",
+		Short: "Serve MCP over stdin/stdout",
+		Long: `Serve the Model Context Protocol over newline-delimited JSON-RPC messages on
+stdin and stdout, for MCP clients that launch the server as a subprocess.
+All diagnostic output is written to stderr.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// stdout carries the protocol stream, keep everything else off it
+			log.SetOutput(os.Stderr)
+
+			redactor, err := newRedactor()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error configuring redaction: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := configureExport(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error configuring log export: %v\n", err)
+				os.Exit(1)
+			}
+
+			p, err := loadPolicy()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error configuring authorization: %v\n", err)
+				os.Exit(1)
+			}
+
+			k8sClient, err := kubernetes.NewClient(kubeconfig, false)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating Kubernetes client: %v\n", err)
+				os.Exit(1)
+			}
+
+			handler := mcp.NewHandler(k8sClient, k8sClient.GetClientset())
+			handler.SetRedactor(redactor)
+			handler.SetPolicy(p)
+			handler.SetReadOnly(readOnly)
+			handler.SetProtected(protectedNamespaces, protectedResources)
+			if err := mcp.NewServer(handler).ServeStdio(context.Background(), os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error serving stdio: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	stdioCmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "Path to kubeconfig file (defaults to in-cluster config if empty)")
+	addRedactionFlags(stdioCmd)
+	addExportFlags(stdioCmd)
+	addPolicyFlags(stdioCmd)
+	addGuardFlags(stdioCmd)
 
 	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(stdioCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
+
+// addRedactionFlags registers the flags configuring redaction of sensitive
+// values
+func addRedactionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&redactConfig, "redact-config", "", "Path to a YAML file with additional redaction rules and built-in detectors to disable")
+	cmd.Flags().BoolVar(&revealSecrets, "reveal-secrets", false, "Return the data of Secrets to every caller instead of masking it")
+}
+
+// newRedactor creates the redactor configured by the flags
+func newRedactor() (*redact.Redactor, error) {
+	var cfg redact.Config
+	if redactConfig != "" {
+		var err error
+		if cfg, err = redact.LoadConfig(redactConfig); err != nil {
+			return nil, err
+		}
+	}
+	if revealSecrets {
+		cfg.RevealSecrets = true
+	}
+
+	return redact.New(cfg)
+}
+
+// addExportFlags registers the flags configuring log export formats
+func addExportFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&syslogEnterpriseID, "syslog-enterprise-id", logs.DefaultSyslogEnterpriseID, "IANA private enterprise number qualifying the structured data IDs of syslog exports (defaults to the RFC 5612 documentation number)")
+}
+
+// configureExport registers the export formats configured by the flags
+func configureExport() error {
+	format, err := logs.NewSyslogFormat(syslogEnterpriseID)
+	if err != nil {
+		return err
+	}
+	logs.RegisterExportFormat(format)
+	return nil
+}
+
+// addPolicyFlags registers the flags configuring authorization of commands
+func addPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&policyFile, "policy-file", "", "Path to a YAML file of rules granting or denying commands per user and group (defaults to allowing every command)")
+}
+
+// addGuardFlags registers the flags guarding against modifying the cluster
+func addGuardFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Reject every command that could modify the cluster and hide them from tool listings")
+	cmd.Flags().StringSliceVar(&protectedNamespaces, "protected-namespaces", mcp.DefaultProtectedNamespaces, "Namespaces where deletes need a confirmation token from a dry run")
+	cmd.Flags().StringSliceVar(&protectedResources, "protected-resources", mcp.DefaultProtectedResources, "Plural resource types, optionally qualified by group, whose deletes need a confirmation token from a dry run")
+}
+
+// loadPolicy reads the authorization policy named by the flags, or returns
+// nil when none is
+func loadPolicy() (*policy.Policy, error) {
+	if policyFile == "" {
+		return nil, nil
+	}
+	return policy.LoadFile(policyFile)
+}
+
+// newAuthenticator creates the authenticators of the HTTP API configured by
+// the flags, or nil when none is
+func newAuthenticator() (auth.Authenticator, error) {
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return nil, fmt.Errorf("--tls-cert-file and --tls-key-file must be given together")
+	}
+
+	var chain auth.Chain
+	if clientCAFile != "" {
+		if tlsCertFile == "" {
+			return nil, fmt.Errorf("--client-ca-file requires --tls-cert-file and --tls-key-file")
+		}
+		chain = append(chain, auth.CertificateAuthenticator{})
+	}
+	if tokenAuthFile != "" {
+		tokens, err := auth.LoadTokenFile(tokenAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, tokens)
+	}
+	if oidcIssuerURL != "" {
+		oidc, err := auth.NewOIDCAuthenticator(auth.OIDCConfig{
+			IssuerURL:     oidcIssuerURL,
+			ClientID:      oidcClientID,
+			UsernameClaim: oidcUsernameClaim,
+			GroupsClaim:   oidcGroupsClaim,
+			JWKSURL:       oidcJWKSURL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, oidc)
+	}
+
+	if len(chain) == 0 {
+		if impersonate {
+			return nil, fmt.Errorf("--impersonate requires an authentication method: --token-auth-file, --oidc-issuer-url or --client-ca-file")
+		}
+		return nil, nil
+	}
+	return chain, nil
+}
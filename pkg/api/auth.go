@@ -0,0 +1,66 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/auth"
+)
+
+// authenticate wraps a handler so that it only runs for authenticated
+// requests, with the caller's identity attached to the request context.
+// Requests pass through unchanged when no authenticator is configured.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Authenticator == nil {
+			next(w, r)
+			return
+		}
+
+		identity, err := s.cfg.Authenticator.Authenticate(r)
+		if err != nil {
+			log.Printf("Rejected unauthenticated request from %s: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="k8s-mcp-server"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	}
+}
+
+// requestUser returns the username of the request's caller, or an empty
+// string for unauthenticated requests
+func requestUser(r *http.Request) string {
+	if identity, ok := auth.IdentityFrom(r.Context()); ok {
+		return identity.Username
+	}
+	return ""
+}
+
+// serverTLSConfig returns the TLS configuration of the server. With a
+// client CA file, client certificates are requested and verified against
+// it; clients without one can still authenticate by other means.
+func serverTLSConfig(clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/logs"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/mcp"
+)
+
+// Trailers sent after a streamed log export, once the outcome is known
+const (
+	warningsTrailer = "X-Log-Warnings"
+	redactedTrailer = "X-Redacted-Count"
+)
+
+// exportCompression is a compression applied to log exports
+type exportCompression struct {
+	contentType string
+	extension   string
+	newWriter   func(io.Writer) (io.WriteCloser, error)
+}
+
+// exportCompressions holds the compressions selected by the compression
+// query parameter
+var exportCompressions = map[string]*exportCompression{
+	"gzip": {
+		contentType: "application/gzip",
+		extension:   "gz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	},
+	"zstd": {
+		contentType: "application/zstd",
+		extension:   "zst",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			// A single encoder goroutine keeps memory use bounded
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		},
+	},
+}
+
+// unsafeFilenameChars matches the characters replaced in export file names
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportWriter writes a log export as a file download. The headers are only
+// sent with the first byte, so an export that fails up front can still
+// answer with an error status.
+type exportWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	compression *exportCompression
+
+	started bool
+	out     io.Writer
+	closer  io.Closer
+}
+
+// Write implements io.Writer
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return 0, err
+		}
+	}
+	return e.out.Write(p)
+}
+
+// start sends the headers and sets up compression
+func (e *exportWriter) start() error {
+	e.started = true
+
+	header := e.w.Header()
+	header.Set("Content-Type", e.contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": e.filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Trailer", warningsTrailer+", "+redactedTrailer)
+	e.w.WriteHeader(http.StatusOK)
+
+	e.out = e.w
+	if e.compression != nil {
+		compressor, err := e.compression.newWriter(e.w)
+		if err != nil {
+			return fmt.Errorf("failed to start compression: %v", err)
+		}
+		e.out, e.closer = compressor, compressor
+	}
+	return nil
+}
+
+// finish completes the export, starting it if nothing was written
+func (e *exportWriter) finish() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// exportLogs streams a log export to the client as a file download in the
+// requested format, optionally compressed with gzip or zstd. Warnings about
+// pods that could not be read and the number of redacted values are sent as
+// trailers. An export failing after it started aborts the connection, so a
+// client never mistakes a truncated export for a complete one.
+func (s *Server) exportLogs(w http.ResponseWriter, r *http.Request, cmd *mcp.Command) {
+	format, err := logs.GetExportFormat(cmd.LogOptions.Format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ew := &exportWriter{
+		w:           w,
+		contentType: format.ContentType,
+		filename:    exportFilename(cmd, format.Extension),
+	}
+	if name := r.URL.Query().Get("compression"); name != "" && name != "none" {
+		compression, ok := exportCompressions[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unsupported compression: %s (expected gzip or zstd)", name), http.StatusBadRequest)
+			return
+		}
+		ew.compression = compression
+		ew.contentType = compression.contentType
+		ew.filename += "." + compression.extension
+	}
+
+	resp, err := s.mcpHandler.ExportLogs(r.Context(), cmd, ew)
+	if err != nil {
+		resp, _ = mcp.NewErrorResponse(err)
+	}
+
+	if !resp.Success {
+		if !ew.started {
+			writeResponse(w, resp)
+			return
+		}
+		log.Printf("Log export failed after it started: %s", resp.Error)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err := ew.finish(); err != nil {
+		log.Printf("Failed to complete log export: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Set(warningsTrailer, strings.Join(resp.Warnings, "; "))
+	w.Header().Set(redactedTrailer, strconv.Itoa(resp.Redacted))
+}
+
+// exportFilename names the file of a log export after its namespace, its
+// source and the time of the export
+func exportFilename(cmd *mcp.Command, extension string) string {
+	source := cmd.LogOptions.Pod
+	if source == "" {
+		source = cmd.LogOptions.Workload
+	}
+	if source == "" {
+		source = "selector"
+	}
+
+	name := fmt.Sprintf("%s-%s-%s", cmd.Namespace, source, time.Now().UTC().Format("20060102T150405Z"))
+	return unsafeFilenameChars.ReplaceAllString(name, "-") + "." + extension
+}
@@ -5,25 +5,71 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/auth"
 	"github.com/mayukhsarkar/k8s-mcp-server/pkg/kubernetes"
 	"github.com/mayukhsarkar/k8s-mcp-server/pkg/mcp"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/policy"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
+	"sigs.k8s.io/yaml"
 )
 
+// Config configures the HTTP API server
+type Config struct {
+	Port           int
+	KubeconfigPath string
+	// Redactor masks sensitive values in every response; nil disables
+	// redaction
+	Redactor *redact.Redactor
+	// Authenticator authenticates every request except health checks; nil
+	// leaves the API open to anyone who can reach it
+	Authenticator auth.Authenticator
+	// Policy authorizes the commands of each caller; nil allows every
+	// command
+	Policy *policy.Policy
+	// Impersonate makes Kubernetes requests on behalf of the authenticated
+	// caller, so cluster RBAC applies to each user; requires Authenticator
+	Impersonate bool
+	// ReadOnly rejects every command that could modify the cluster
+	ReadOnly bool
+	// ProtectedNamespaces and ProtectedResources are where deletes need a
+	// confirmation token from a dry run
+	ProtectedNamespaces []string
+	ProtectedResources  []string
+	// TLSCertFile and TLSKeyFile serve HTTPS instead of HTTP. ClientCAFile
+	// additionally verifies client certificates signed by its CAs, which
+	// auth.CertificateAuthenticator turns into identities.
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+	// MaxSessions bounds the number of live MCP sessions; initialize
+	// requests beyond it are rejected with 503. Defaults to
+	// DefaultMaxSessions.
+	MaxSessions int
+	// MaxSessionsPerUser bounds the live MCP sessions of each user, or of
+	// each client address for unauthenticated callers; initialize requests
+	// beyond it are rejected with 429. Defaults to
+	// DefaultMaxSessionsPerUser.
+	MaxSessionsPerUser int
+}
+
 // Server represents the HTTP API server
 type Server struct {
-	port       int
+	cfg        Config
 	k8sClient  *kubernetes.Client
 	mcpHandler *mcp.Handler
+	mcpServer  *mcp.Server
+	sessions   *sessionStore
 }
 
 // NewServer creates a new HTTP API server
-func NewServer(port int, kubeconfigPath string) *Server {
+func NewServer(cfg Config) *Server {
 	// Create Kubernetes client
-	k8sClient, err := kubernetes.NewClient(kubeconfigPath)
+	k8sClient, err := kubernetes.NewClient(cfg.KubeconfigPath, cfg.Impersonate)
 	if err != nil {
 		log.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
@@ -33,31 +79,82 @@ func NewServer(port int, kubeconfigPath string) *Server {
 
 	// Create MCP handler
 	mcpHandler := mcp.NewHandler(k8sClient, clientset)
+	mcpHandler.SetRedactor(cfg.Redactor)
+	mcpHandler.SetPolicy(cfg.Policy)
+	mcpHandler.SetReadOnly(cfg.ReadOnly)
+	mcpHandler.SetProtected(cfg.ProtectedNamespaces, cfg.ProtectedResources)
+
+	maxSessions := cfg.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	maxSessionsPerUser := cfg.MaxSessionsPerUser
+	if maxSessionsPerUser <= 0 {
+		maxSessionsPerUser = DefaultMaxSessionsPerUser
+	}
 
 	return &Server{
-		port:       port,
+		cfg:        cfg,
 		k8sClient:  k8sClient,
 		mcpHandler: mcpHandler,
+		mcpServer:  mcp.NewServer(mcpHandler),
+		sessions:   newSessionStore(defaultSessionIdleTimeout, maxSessions, maxSessionsPerUser),
 	}
 }
 
 // Start starts the HTTP API server
 func (s *Server) Start() error {
 	// Register API routes
-	http.HandleFunc("/api/v1/mcp", s.handleMCPRequest)
-	http.HandleFunc("/api/v1/resources/", s.handleResourceRequest)
-	http.HandleFunc("/api/v1/logs/", s.handleLogRequest)
+	http.HandleFunc("/api/v1/mcp", s.authenticate(s.handleMCPRequest))
+	http.HandleFunc("/api/v1/resources/", s.authenticate(s.handleResourceRequest))
+	http.HandleFunc("/api/v1/logs/", s.authenticate(s.handleLogRequest))
 	http.HandleFunc("/health", s.handleHealthCheck)
 
+	// Expire idle MCP sessions in the background
+	go s.sessions.expireIdle()
+
 	// Start the server
-	addr := fmt.Sprintf(":%d", s.port)
-	log.Printf("Starting server on %s", addr)
-	return http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", s.cfg.Port)}
+	if s.cfg.Authenticator == nil {
+		log.Printf("WARNING: authentication is disabled; anyone who can reach %s can act with the server's Kubernetes permissions", server.Addr)
+	}
+
+	if s.cfg.TLSCertFile == "" {
+		if s.cfg.Authenticator != nil {
+			log.Printf("WARNING: serving without TLS; credentials are sent in cleartext")
+		}
+		log.Printf("Starting server on %s", server.Addr)
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := serverTLSConfig(s.cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+
+	log.Printf("Starting server on %s with TLS", server.Addr)
+	return server.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
 }
 
-// handleMCPRequest handles MCP protocol requests
+// handleMCPRequest handles MCP protocol requests. POST carries client
+// messages, GET opens a server-to-client event stream for a session and
+// DELETE terminates a session.
 func (s *Server) handleMCPRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if !validOrigin(r) {
+		http.Error(w, "Forbidden origin", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet:
+		s.handleSessionStream(w, r)
+		return
+	case http.MethodDelete:
+		s.handleSessionDelete(w, r)
+		return
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -69,26 +166,21 @@ func (s *Server) handleMCPRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse MCP command
-	cmd, err := mcp.ParseCommand(body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse command: %v", err), http.StatusBadRequest)
+	// JSON-RPC messages are handled by the MCP protocol server; anything
+	// else is treated as a legacy Command
+	if mcp.IsJSONRPC(body) {
+		s.handleJSONRPC(w, r, body)
 		return
 	}
 
-	// Handle command
-	resp, err := s.mcpHandler.HandleCommand(cmd)
+	// Parse MCP command
+	cmd, err := mcp.ParseCommand(body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to handle command: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to parse command: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	if !resp.Success {
-		w.WriteHeader(http.StatusBadRequest)
-	}
-	json.NewEncoder(w).Encode(resp)
+	s.handleCommand(w, r, cmd)
 }
 
 // handleResourceRequest handles Kubernetes resource requests
@@ -114,12 +206,49 @@ func (s *Server) handleResourceRequest(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
+		if watch, _ := strconv.ParseBool(r.URL.Query().Get("watch")); watch {
+			// Watch resources, streaming events as they happen
+			timeoutSeconds, err := queryInt(r, "timeoutSeconds")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			maxEvents, err := queryInt(r, "maxEvents")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			s.streamCommand(w, r, &mcp.Command{
+				Type:            mcp.WatchCommand,
+				Resource:        resourceType,
+				Name:            name,
+				Namespace:       namespace,
+				LabelSelector:   r.URL.Query().Get("labelSelector"),
+				FieldSelector:   r.URL.Query().Get("fieldSelector"),
+				ResourceVersion: r.URL.Query().Get("resourceVersion"),
+				TimeoutSeconds:  timeoutSeconds,
+				MaxEvents:       int(maxEvents),
+			})
+			return
+		}
+
 		if name == "" {
 			// List resources
+			limit, err := queryInt(r, "limit")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			cmd = &mcp.Command{
-				Type:      mcp.ListCommand,
-				Resource:  resourceType,
-				Namespace: namespace,
+				Type:          mcp.ListCommand,
+				Resource:      resourceType,
+				Namespace:     namespace,
+				LabelSelector: r.URL.Query().Get("labelSelector"),
+				FieldSelector: r.URL.Query().Get("fieldSelector"),
+				Limit:         limit,
+				Continue:      r.URL.Query().Get("continue"),
 			}
 		} else {
 			// Get resource
@@ -144,37 +273,69 @@ func (s *Server) handleResourceRequest(w http.ResponseWriter, r *http.Request) {
 			Namespace: namespace,
 			Data:      body,
 		}
-	case http.MethodDelete:
-		// Delete resource
+	case http.MethodPut:
+		// Update resource
 		if name == "" {
-			http.Error(w, "Resource name is required for DELETE", http.StatusBadRequest)
+			http.Error(w, "Resource name is required for PUT", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		cmd = &mcp.Command{
-			Type:      mcp.DeleteCommand,
+			Type:      mcp.UpdateCommand,
 			Resource:  resourceType,
 			Name:      name,
 			Namespace: namespace,
+			Data:      body,
+		}
+	case http.MethodPatch:
+		// Patch or server-side apply, depending on the content type
+		if name == "" {
+			http.Error(w, "Resource name is required for PATCH", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		cmd, err = patchCommand(r, resourceType, name, namespace, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+	case http.MethodDelete:
+		// Delete resource
+		if name == "" {
+			http.Error(w, "Resource name is required for DELETE", http.StatusBadRequest)
+			return
+		}
+
+		cmd = &mcp.Command{
+			Type:              mcp.DeleteCommand,
+			Resource:          resourceType,
+			Name:              name,
+			Namespace:         namespace,
+			ConfirmationToken: r.URL.Query().Get("confirmationToken"),
 		}
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Handle command
-	resp, err := s.mcpHandler.HandleCommand(cmd)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to handle command: %v", err), http.StatusInternalServerError)
-		return
+	// Writes are previewed without persisting them with dryRun=true
+	if cmd.Type.Mutating() {
+		cmd.DryRun, _ = strconv.ParseBool(r.URL.Query().Get("dryRun"))
 	}
 
-	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	if !resp.Success {
-		w.WriteHeader(http.StatusBadRequest)
-	}
-	json.NewEncoder(w).Encode(resp)
+	s.handleCommand(w, r, cmd)
 }
 
 // handleLogRequest handles log requests
@@ -184,8 +345,9 @@ func (s *Server) handleLogRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse path: /api/v1/logs/{namespace}/{pod}
-	// or /api/v1/logs/search or /api/v1/logs/export
+	// Parse path: /api/v1/logs/{namespace}[/{pod}]
+	// or /api/v1/logs/search, /api/v1/logs/stats, /api/v1/logs/summarize
+	// or /api/v1/logs/export
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 4 {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
@@ -211,15 +373,40 @@ func (s *Server) handleLogRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Parse boolean parameters
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	previous, _ := strconv.ParseBool(r.URL.Query().Get("previous"))
+	allContainers, _ := strconv.ParseBool(r.URL.Query().Get("allContainers"))
+	cluster, _ := strconv.ParseBool(r.URL.Query().Get("cluster"))
+	maxTemplates, err := queryInt(r, "maxTemplates")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Create log options
 	logOptions := &mcp.LogOptions{
-		Container: container,
-		Since:     since,
-		Tail:      tail,
-		Pattern:   pattern,
-		LogLevel:  logLevel,
-		Format:    format,
+		Container:   container,
+		Since:       since,
+		Tail:        tail,
+		Pattern:     pattern,
+		LogLevel:    logLevel,
+		Format:      format,
+		Follow:      follow,
+		MaxDuration: r.URL.Query().Get("maxDuration"),
+		Selector:    r.URL.Query().Get("selector"),
+		Workload:    r.URL.Query().Get("workload"),
+		Parser:      r.URL.Query().Get("parser"),
+		Query:       r.URL.Query().Get("query"),
+
+		Multiline:      r.URL.Query().Get("multiline"),
+		MultilineStart: r.URL.Query().Get("multilineStart"),
+		Previous:       previous,
+		AllContainers:  allContainers,
+		Cluster:        cluster,
+		MaxTemplates:   int(maxTemplates),
 	}
+	multiPod := logOptions.Selector != "" || logOptions.Workload != ""
 
 	var cmd *mcp.Command
 
@@ -229,23 +416,64 @@ func (s *Server) handleLogRequest(w http.ResponseWriter, r *http.Request) {
 		// Search logs
 		namespace := r.URL.Query().Get("namespace")
 		pod := r.URL.Query().Get("pod")
-		if namespace == "" || pod == "" {
-			http.Error(w, "Namespace and pod are required for log search", http.StatusBadRequest)
+		if namespace == "" || (pod == "" && !multiPod) {
+			http.Error(w, "Namespace and one of pod, selector or workload are required for log search", http.StatusBadRequest)
 			return
 		}
 
+		// Parse context parameters
+		for name, target := range map[string]*int{"before": &logOptions.Before, "after": &logOptions.After, "context": &logOptions.Context} {
+			n, err := queryInt(r, name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*target = int(n)
+		}
+
 		logOptions.Pod = pod
 		cmd = &mcp.Command{
 			Type:       mcp.SearchLogsCommand,
 			Namespace:  namespace,
 			LogOptions: logOptions,
 		}
+	case "stats":
+		// Summarize logs
+		namespace := r.URL.Query().Get("namespace")
+		pod := r.URL.Query().Get("pod")
+		if namespace == "" || (pod == "" && !multiPod) {
+			http.Error(w, "Namespace and one of pod, selector or workload are required for log stats", http.StatusBadRequest)
+			return
+		}
+
+		logOptions.Pod = pod
+		logOptions.Bucket = r.URL.Query().Get("bucket")
+		cmd = &mcp.Command{
+			Type:       mcp.LogStatsCommand,
+			Namespace:  namespace,
+			LogOptions: logOptions,
+		}
+	case "summarize":
+		// Group logs into templates
+		namespace := r.URL.Query().Get("namespace")
+		pod := r.URL.Query().Get("pod")
+		if namespace == "" || (pod == "" && !multiPod) {
+			http.Error(w, "Namespace and one of pod, selector or workload are required for log summaries", http.StatusBadRequest)
+			return
+		}
+
+		logOptions.Pod = pod
+		cmd = &mcp.Command{
+			Type:       mcp.SummarizeLogsCommand,
+			Namespace:  namespace,
+			LogOptions: logOptions,
+		}
 	case "export":
 		// Export logs
 		namespace := r.URL.Query().Get("namespace")
 		pod := r.URL.Query().Get("pod")
-		if namespace == "" || pod == "" {
-			http.Error(w, "Namespace and pod are required for log export", http.StatusBadRequest)
+		if namespace == "" || (pod == "" && !multiPod) {
+			http.Error(w, "Namespace and one of pod, selector or workload are required for log export", http.StatusBadRequest)
 			return
 		}
 
@@ -255,19 +483,24 @@ func (s *Server) handleLogRequest(w http.ResponseWriter, r *http.Request) {
 		}
 
 		logOptions.Pod = pod
-		cmd = &mcp.Command{
+		s.exportLogs(w, r, &mcp.Command{
 			Type:       mcp.ExportLogsCommand,
 			Namespace:  namespace,
 			LogOptions: logOptions,
-		}
+		})
+		return
 	default:
-		// Get logs for a specific pod
+		// Get logs for a specific pod, or for the pods selected by the
+		// selector and workload parameters
 		namespace := parts[3]
-		if len(parts) < 5 {
+		var pod string
+		if len(parts) > 4 {
+			pod = parts[4]
+		}
+		if pod == "" && !multiPod {
 			http.Error(w, "Pod name is required", http.StatusBadRequest)
 			return
 		}
-		pod := parts[4]
 
 		logOptions.Pod = pod
 		cmd = &mcp.Command{
@@ -275,21 +508,95 @@ func (s *Server) handleLogRequest(w http.ResponseWriter, r *http.Request) {
 			Namespace:  namespace,
 			LogOptions: logOptions,
 		}
+
+		// Followed logs are streamed as they are written
+		if logOptions.Follow && !logOptions.Cluster {
+			s.streamCommand(w, r, cmd)
+			return
+		}
 	}
 
-	// Handle command
-	resp, err := s.mcpHandler.HandleCommand(cmd)
+	s.handleCommand(w, r, cmd)
+}
+
+// handleCommand runs a command and writes its response
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request, cmd *mcp.Command) {
+	resp, err := s.mcpHandler.HandleCommand(r.Context(), cmd)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to handle command: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Send response
-	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, resp)
+}
+
+// writeResponse writes a command response. Failures use the status code
+// carried by the response, defaulting to 400 Bad Request.
+func writeResponse(w http.ResponseWriter, resp *mcp.Response) {
+	status := http.StatusOK
 	if !resp.Success {
-		w.WriteHeader(http.StatusBadRequest)
+		status = http.StatusBadRequest
+		if resp.Code >= 400 && resp.Code < 600 {
+			status = resp.Code
+		}
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// patchCommand builds the command for a PATCH request. The content type
+// selects the patch format as it does for the Kubernetes API:
+// application/apply-patch+yaml requests a server-side apply, whose field
+// manager and force flag are taken from the fieldManager and force query
+// parameters.
+func patchCommand(r *http.Request, resourceType, name, namespace string, body []byte) (*mcp.Command, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	cmd := &mcp.Command{
+		Type:      mcp.PatchCommand,
+		Resource:  resourceType,
+		Name:      name,
+		Namespace: namespace,
+		Data:      body,
+	}
+
+	switch contentType {
+	case "application/json-patch+json":
+		cmd.PatchType = "json"
+	case "application/merge-patch+json", "application/json", "":
+		cmd.PatchType = "merge"
+	case "application/strategic-merge-patch+json":
+		cmd.PatchType = "strategic"
+	case "application/apply-patch+yaml", "application/apply-patch+json":
+		data, err := yaml.YAMLToJSON(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid apply patch: %v", err)
+		}
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+		cmd.Type = mcp.ApplyCommand
+		cmd.Data = data
+		cmd.FieldManager = r.URL.Query().Get("fieldManager")
+		cmd.Force = force
+	default:
+		return nil, fmt.Errorf("unsupported patch content type: %s", contentType)
+	}
+
+	return cmd, nil
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s parameter: %v", name, err)
 	}
-	json.NewEncoder(w).Encode(resp)
+	return n, nil
 }
 
 // handleHealthCheck handles health check requests
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/mcp"
+)
+
+// ndjsonWriter streams events as newline-delimited JSON. The response is
+// only started when the first event is written, so a command that fails up
+// front can still answer with a regular error status.
+type ndjsonWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+// Event implements mcp.EventSink
+func (n *ndjsonWriter) Event(event string, data interface{}) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.started {
+		n.w.Header().Set("Content-Type", "application/x-ndjson")
+		n.w.Header().Set("X-Content-Type-Options", "nosniff")
+		n.w.WriteHeader(http.StatusOK)
+		n.started = true
+	}
+
+	if _, err := n.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	n.flusher.Flush()
+	return nil
+}
+
+// streamCommand runs a long-running command and streams its events to the
+// client as they happen. Clients accepting text/event-stream receive
+// Server-Sent Events named after the event type, followed by a final
+// "result" event with the command's status; others receive one JSON value
+// per line. The streamed data is not repeated in the final status.
+func (s *Server) streamCommand(w http.ResponseWriter, r *http.Request, cmd *mcp.Command) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if acceptsEventStream(r) {
+		sse, err := newSSEWriter(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := s.mcpHandler.HandleCommand(mcp.WithEventSink(r.Context(), sse), cmd)
+		if err != nil {
+			resp, _ = mcp.NewErrorResponse(err)
+		}
+		resp.Data = nil
+
+		if err := sse.writeEvent("result", resp); err != nil && r.Context().Err() == nil {
+			log.Printf("Failed to write stream result: %v", err)
+		}
+		return
+	}
+
+	ndjson := &ndjsonWriter{w: w, flusher: flusher}
+	resp, err := s.mcpHandler.HandleCommand(mcp.WithEventSink(r.Context(), ndjson), cmd)
+	if err != nil {
+		resp, _ = mcp.NewErrorResponse(err)
+	}
+
+	switch {
+	case !ndjson.started && !resp.Success:
+		writeResponse(w, resp)
+	case !ndjson.started:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	case !resp.Success:
+		// The failure can only be reported in-band once streaming started
+		resp.Data = nil
+		ndjson.Event("result", resp)
+	}
+}
@@ -0,0 +1,511 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/mcp"
+)
+
+const (
+	// sessionHeader carries the MCP session ID on Streamable HTTP requests
+	sessionHeader = "Mcp-Session-Id"
+	// protocolVersionHeader carries the negotiated MCP protocol version
+	protocolVersionHeader = "Mcp-Protocol-Version"
+
+	// defaultSessionIdleTimeout is how long an unused session is kept
+	defaultSessionIdleTimeout = 30 * time.Minute
+	// DefaultMaxSessions bounds the number of live sessions
+	DefaultMaxSessions = 1000
+	// DefaultMaxSessionsPerUser bounds the live sessions of a single caller
+	DefaultMaxSessionsPerUser = 50
+	// sessionSweepInterval is how often idle sessions are looked for
+	sessionSweepInterval = time.Minute
+	// sseKeepAliveInterval is how often a comment is sent on idle streams
+	sseKeepAliveInterval = 25 * time.Second
+	// sessionStreamBuffer bounds notifications queued for a standalone stream
+	sessionStreamBuffer = 64
+)
+
+// streamSession tracks an MCP session established over Streamable HTTP
+type streamSession struct {
+	id      string
+	session *mcp.Session
+	// owner is the user who created the session; only they may use it
+	owner string
+	// quota is the caller the session counts against: the owner, or the
+	// client address for unauthenticated callers
+	quota string
+
+	mu         sync.Mutex
+	lastActive time.Time
+	active     int
+	stream     chan *mcp.Notification
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+// Notify implements mcp.Notifier. Notifications are queued for the
+// standalone GET stream and dropped when the client is not listening.
+func (ss *streamSession) Notify(notification *mcp.Notification) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.stream == nil {
+		return nil
+	}
+
+	select {
+	case ss.stream <- notification:
+		return nil
+	default:
+		return fmt.Errorf("notification queue for session %s is full", ss.id)
+	}
+}
+
+// acquire marks the session as in use so it is not expired
+func (ss *streamSession) acquire() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.active++
+	ss.lastActive = time.Now()
+}
+
+// release marks the end of a use of the session
+func (ss *streamSession) release() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.active--
+	ss.lastActive = time.Now()
+}
+
+// openStream attaches a standalone stream to the session
+func (ss *streamSession) openStream() (<-chan *mcp.Notification, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.stream != nil {
+		return nil, fmt.Errorf("session %s already has an open stream", ss.id)
+	}
+	ss.stream = make(chan *mcp.Notification, sessionStreamBuffer)
+	return ss.stream, nil
+}
+
+// closeStream detaches the standalone stream from the session
+func (ss *streamSession) closeStream() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.stream = nil
+}
+
+// idleSince reports whether the session has been unused since the cutoff
+func (ss *streamSession) idleSince(cutoff time.Time) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.active == 0 && ss.stream == nil && ss.lastActive.Before(cutoff)
+}
+
+// close terminates the session and cancels its in-flight requests
+func (ss *streamSession) close() {
+	ss.closeOnce.Do(func() {
+		close(ss.closed)
+		ss.session.Close()
+	})
+}
+
+// Errors rejecting new sessions
+var (
+	errTooManySessions     = errors.New("too many MCP sessions; retry once idle sessions have expired")
+	errTooManyUserSessions = errors.New("too many MCP sessions for this caller; delete unused sessions or retry once they have expired")
+)
+
+// sessionStore holds the live Streamable HTTP sessions
+type sessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*streamSession
+	perQuota    map[string]int
+	idleTimeout time.Duration
+	maxSessions int
+	maxPerQuota int
+}
+
+// newSessionStore creates an empty session store holding at most
+// maxSessions sessions, and at most maxPerUser for each caller
+func newSessionStore(idleTimeout time.Duration, maxSessions, maxPerUser int) *sessionStore {
+	return &sessionStore{
+		sessions:    make(map[string]*streamSession),
+		perQuota:    make(map[string]int),
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		maxPerQuota: maxPerUser,
+	}
+}
+
+// create registers a new session with a random ID, owned by the given user
+// and counted against quota. It returns errTooManySessions when the store
+// is full and errTooManyUserSessions when quota has no sessions left.
+func (st *sessionStore) create(owner, quota string) (*streamSession, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %v", err)
+	}
+
+	ss := &streamSession{
+		id:         hex.EncodeToString(buf),
+		session:    mcp.NewSession(),
+		owner:      owner,
+		quota:      quota,
+		lastActive: time.Now(),
+		closed:     make(chan struct{}),
+	}
+	ss.session.SetNotifier(ss)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.perQuota[quota] >= st.maxPerQuota {
+		ss.session.Close()
+		return nil, errTooManyUserSessions
+	}
+	if len(st.sessions) >= st.maxSessions {
+		ss.session.Close()
+		return nil, errTooManySessions
+	}
+	st.sessions[ss.id] = ss
+	st.perQuota[quota]++
+
+	return ss, nil
+}
+
+// get looks up a session by ID. Sessions owned by another user are not
+// found, so session IDs cannot be used across identities.
+func (st *sessionStore) get(id, owner string) *streamSession {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ss, ok := st.sessions[id]
+	if !ok || ss.owner != owner {
+		return nil
+	}
+	return ss
+}
+
+// remove terminates and forgets a session owned by the given user
+func (st *sessionStore) remove(id, owner string) bool {
+	st.mu.Lock()
+	ss, ok := st.sessions[id]
+	ok = ok && ss.owner == owner
+	if ok {
+		delete(st.sessions, id)
+		if st.perQuota[ss.quota]--; st.perQuota[ss.quota] <= 0 {
+			delete(st.perQuota, ss.quota)
+		}
+	}
+	st.mu.Unlock()
+
+	if ok {
+		ss.close()
+	}
+	return ok
+}
+
+// expireIdle periodically removes sessions that have been idle for longer
+// than the idle timeout. It never returns.
+func (st *sessionStore) expireIdle() {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-st.idleTimeout)
+
+		st.mu.Lock()
+		var expired []*streamSession
+		for _, ss := range st.sessions {
+			if ss.idleSince(cutoff) {
+				expired = append(expired, ss)
+			}
+		}
+		st.mu.Unlock()
+
+		for _, ss := range expired {
+			log.Printf("Expiring idle MCP session %s", ss.id)
+			st.remove(ss.id, ss.owner)
+		}
+	}
+}
+
+// sseWriter writes Server-Sent Events to an HTTP response
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter starts an event stream response
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by the response writer")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// writeEvent writes a single event with a JSON payload. An empty event name
+// uses the default "message" event type.
+func (s *sseWriter) writeEvent(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event != "" {
+		if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// writeComment writes an SSE comment, used to keep idle streams open
+func (s *sseWriter) writeComment(comment string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Event implements mcp.EventSink
+func (s *sseWriter) Event(event string, data interface{}) error {
+	return s.writeEvent(event, data)
+}
+
+// Notify implements mcp.Notifier
+func (s *sseWriter) Notify(notification *mcp.Notification) error {
+	return s.writeEvent("", notification)
+}
+
+// acceptsEventStream reports whether the client accepts an SSE response
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// validOrigin guards against DNS rebinding by rejecting browser requests
+// whose Origin does not match the requested host
+func validOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// validProtocolVersion checks the protocol version header sent by clients
+// after initialization
+func validProtocolVersion(r *http.Request) bool {
+	version := r.Header.Get(protocolVersionHeader)
+	return version == "" || mcp.IsSupportedProtocolVersion(version)
+}
+
+// handleJSONRPC handles a JSON-RPC message POSTed to the MCP endpoint. An
+// initialize request starts a new session whose ID is returned in the
+// Mcp-Session-Id header; every later message must carry that header and is
+// bound to the session.
+func (s *Server) handleJSONRPC(w http.ResponseWriter, r *http.Request, body []byte) {
+	msg, err := mcp.ParseMessage(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, s.mcpServer.HandleMessage(r.Context(), nil, body))
+		return
+	}
+
+	if !validProtocolVersion(r) {
+		http.Error(w, fmt.Sprintf("Unsupported protocol version: %s", r.Header.Get(protocolVersionHeader)), http.StatusBadRequest)
+		return
+	}
+
+	var ss *streamSession
+	if msg.Method == mcp.MethodInitialize {
+		// Only a request gets the session ID back; a session started by a
+		// notification could never be used or deleted
+		if !msg.IsRequest() {
+			writeJSON(w, http.StatusBadRequest, &mcp.RPCResponse{
+				JSONRPC: mcp.JSONRPCVersion,
+				ID:      json.RawMessage("null"),
+				Error:   &mcp.RPCError{Code: mcp.ErrCodeInvalidRequest, Message: "initialize must be a request with an id"},
+			})
+			return
+		}
+
+		ss, err = s.sessions.create(requestUser(r), sessionQuota(r))
+		switch {
+		case errors.Is(err, errTooManyUserSessions):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, errTooManySessions):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		id := r.Header.Get(sessionHeader)
+		if id == "" {
+			http.Error(w, "Mcp-Session-Id header is required; send initialize first to start a session", http.StatusBadRequest)
+			return
+		}
+		ss = s.sessions.get(id, requestUser(r))
+		if ss == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	ss.acquire()
+	defer ss.release()
+	session := ss.session
+
+	if !msg.IsRequest() {
+		// Notifications and responses are accepted without a body
+		s.mcpServer.HandleMessage(r.Context(), session, body)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Tool calls may emit notifications while they run; stream them on the
+	// response when the client accepts SSE
+	if msg.Method == mcp.MethodToolsCall && acceptsEventStream(r) {
+		sse, err := newSSEWriter(w)
+		if err == nil {
+			ctx := mcp.WithNotifier(r.Context(), sse)
+			if err := sse.writeEvent("", s.mcpServer.HandleMessage(ctx, session, body)); err != nil {
+				log.Printf("Failed to write MCP response: %v", err)
+			}
+			return
+		}
+	}
+
+	resp := s.mcpServer.HandleMessage(r.Context(), session, body)
+	if msg.Method == mcp.MethodInitialize {
+		if resp.Error != nil {
+			s.sessions.remove(ss.id, ss.owner)
+		} else {
+			w.Header().Set(sessionHeader, ss.id)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// sessionQuota returns the caller new sessions are counted against: the
+// authenticated user, or the client address when there is none
+func sessionQuota(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// handleSessionStream opens the standalone SSE stream on which the server
+// sends notifications that are not tied to a client request
+func (s *Server) handleSessionStream(w http.ResponseWriter, r *http.Request) {
+	if !acceptsEventStream(r) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ss := s.sessions.get(r.Header.Get(sessionHeader), requestUser(r))
+	if ss == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	notifications, err := ss.openStream()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer ss.closeStream()
+
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ss.closed:
+			return
+		case notification := <-notifications:
+			if err := sse.writeEvent("", notification); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := sse.writeComment("keep-alive"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSessionDelete terminates a session at the client's request
+func (s *Server) handleSessionDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		http.Error(w, "Session ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.sessions.remove(id, requestUser(r)) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when a request carries
+// no credentials it understands
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+	// Method names how the identity was established: token, oidc or
+	// certificate
+	Method string `json:"method"`
+}
+
+// Authenticator establishes the identity of HTTP requests
+type Authenticator interface {
+	// Authenticate returns the identity of the caller, ErrNoCredentials
+	// when the request carries no credentials for this authenticator, or
+	// another error when the credentials are invalid
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries each authenticator in turn and returns the first identity
+// established. When none succeeds, the first error other than
+// ErrNoCredentials is returned, so a token rejected by one authenticator
+// can still be accepted by another.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	var firstErr error
+	for _, authenticator := range c {
+		identity, err := authenticator.Authenticate(r)
+		if err == nil {
+			return identity, nil
+		}
+		if firstErr == nil && !errors.Is(err, ErrNoCredentials) {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoCredentials
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// identityKey is the context key of the Identity
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller's identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity carried by ctx, if any
+func IdentityFrom(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
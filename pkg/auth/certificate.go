@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// CertificateAuthenticator authenticates TLS client certificates. The
+// certificates are verified against the client CAs by the TLS handshake;
+// like Kubernetes, the common name is the username and the organizations
+// are the groups.
+type CertificateAuthenticator struct{}
+
+// Authenticate implements Authenticator
+func (CertificateAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, errors.New("client certificate has no common name")
+	}
+
+	return &Identity{
+		Username: cert.Subject.CommonName,
+		Groups:   append([]string(nil), cert.Subject.Organization...),
+		Method:   "certificate",
+	}, nil
+}
@@ -0,0 +1,459 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC defaults
+const (
+	defaultUsernameClaim = "sub"
+	defaultGroupsClaim   = "groups"
+	// clockSkew is the leeway allowed on token times
+	clockSkew = time.Minute
+	// minKeyRefreshInterval bounds how often the key set is refetched for
+	// tokens signed with an unknown key
+	minKeyRefreshInterval = time.Minute
+	// keyFetchRetryInterval is how long to wait after a failed fetch of the
+	// key set before trying again
+	keyFetchRetryInterval = 5 * time.Second
+	// oidcFetchTimeout bounds discovery and key set requests
+	oidcFetchTimeout = 10 * time.Second
+)
+
+// signingAlgorithms maps the accepted JWS algorithms to their hashes
+var signingAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// OIDCConfig configures an OIDCAuthenticator
+type OIDCConfig struct {
+	// IssuerURL must match the iss claim of tokens; the provider's keys are
+	// discovered from IssuerURL/.well-known/openid-configuration
+	IssuerURL string
+	// ClientID must be one of the audiences of tokens
+	ClientID string
+	// UsernameClaim and GroupsClaim name the claims holding the username
+	// and groups; they default to sub and groups
+	UsernameClaim string
+	GroupsClaim   string
+	// JWKSURL, if set, is used instead of the key set URL found through
+	// discovery
+	JWKSURL string
+	// HTTPClient fetches the discovery document and key set
+	HTTPClient *http.Client
+}
+
+// OIDCAuthenticator validates JWT bearer tokens issued by an OpenID Connect
+// provider. The provider's signing keys are fetched on first use and
+// refetched when a token is signed with an unknown key.
+type OIDCAuthenticator struct {
+	cfg OIDCConfig
+
+	// fetchMu serializes fetches of the key set, which are made without
+	// holding mu so tokens signed with cached keys are not held up
+	fetchMu sync.Mutex
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	lastFetched time.Time
+	// retryAfter delays the next fetch after a failed one
+	retryAfter time.Time
+}
+
+// NewOIDCAuthenticator creates an OIDCAuthenticator
+func NewOIDCAuthenticator(cfg OIDCConfig) (*OIDCAuthenticator, error) {
+	if cfg.IssuerURL == "" {
+		return nil, errors.New("OIDC issuer URL is required")
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC client ID is required")
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = defaultUsernameClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = defaultGroupsClaim
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: oidcFetchTimeout}
+	}
+
+	return &OIDCAuthenticator{cfg: cfg}, nil
+}
+
+// Authenticate implements Authenticator
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC token: %v", err)
+	}
+	return a.identity(claims)
+}
+
+// jwtHeader is the protected header of a JWS
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature, issuer, audience and validity period of a
+// token and returns its claims
+func (a *OIDCAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	hash, ok := signingAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm '%s'", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(key, header.Alg, hash, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != a.cfg.IssuerURL {
+		return nil, fmt.Errorf("issuer '%s' does not match '%s'", iss, a.cfg.IssuerURL)
+	}
+	if !hasAudience(claims["aud"], a.cfg.ClientID) {
+		return nil, fmt.Errorf("audience does not include '%s'", a.cfg.ClientID)
+	}
+
+	now := time.Now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, errors.New("missing expiry")
+	}
+	if now.After(exp.Add(clockSkew)) {
+		return nil, fmt.Errorf("expired at %s", exp.Format(time.RFC3339))
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(clockSkew).Before(nbf) {
+		return nil, fmt.Errorf("not valid before %s", nbf.Format(time.RFC3339))
+	}
+
+	return claims, nil
+}
+
+// identity extracts the caller's identity from verified claims
+func (a *OIDCAuthenticator) identity(claims map[string]interface{}) (*Identity, error) {
+	username, _ := claims[a.cfg.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("invalid OIDC token: claim '%s' is missing", a.cfg.UsernameClaim)
+	}
+	// Like Kubernetes, an email is only trusted once verified
+	if a.cfg.UsernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return nil, errors.New("invalid OIDC token: email is not verified")
+		}
+	}
+
+	identity := &Identity{Username: username, Method: "oidc"}
+	switch groups := claims[a.cfg.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, g)
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// key returns the signing key with the given ID, refetching the key set
+// when the key is unknown. Tokens without a key ID are accepted when the
+// key set holds a single key. The key set is refetched at most once per
+// minKeyRefreshInterval, and not for keyFetchRetryInterval after a failed
+// fetch; fetches do not depend on the request, so a client going away
+// cannot fail them.
+func (a *OIDCAuthenticator) key(kid string) (crypto.PublicKey, error) {
+	if key, ok, err := a.cachedKey(kid); ok || err != nil {
+		return key, err
+	}
+
+	a.fetchMu.Lock()
+	defer a.fetchMu.Unlock()
+
+	// Another request may have fetched the key set while this one waited
+	if key, ok, err := a.cachedKey(kid); ok || err != nil {
+		return key, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcFetchTimeout)
+	keys, err := a.fetchKeys(ctx)
+	cancel()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		a.retryAfter = time.Now().Add(keyFetchRetryInterval)
+		return nil, err
+	}
+	a.keys = keys
+	a.lastFetched = time.Now()
+
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", kid)
+}
+
+// cachedKey looks up a key in the cached key set. It returns an error when
+// the key is unknown and the key set may not be refetched yet.
+func (a *OIDCAuthenticator) cachedKey(kid string) (crypto.PublicKey, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key, ok := a.lookupKey(kid); ok {
+		return key, true, nil
+	}
+	now := time.Now()
+	if (!a.lastFetched.IsZero() && now.Sub(a.lastFetched) < minKeyRefreshInterval) || now.Before(a.retryAfter) {
+		return nil, false, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+	return nil, false, nil
+}
+
+// lookupKey finds a key in the cached key set
+func (a *OIDCAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// fetchKeys fetches the provider's key set, discovering its URL first
+// unless one is configured
+func (a *OIDCAuthenticator) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := a.cfg.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.fetchJSON(ctx, strings.TrimSuffix(a.cfg.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+		}
+		if discovery.Issuer != a.cfg.IssuerURL {
+			return nil, fmt.Errorf("OIDC discovery returned issuer '%s', expected '%s'", discovery.Issuer, a.cfg.IssuerURL)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.fetchJSON(ctx, jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC keys: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// fetchJSON fetches and decodes a JSON document
+func (a *OIDCAuthenticator) fetchJSON(ctx context.Context, url string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, oidcFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jsonWebKey is a public key of a JWK set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA or EC public key
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC key is not on its curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+// verifySignature checks a JWS signature with a key of the algorithm's type
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, signed, signature []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signing key does not match algorithm '%s'", alg)
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("malformed key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of
+// strings, includes the audience
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// numericDate converts a JWT NumericDate claim to a time
+func numericDate(claim interface{}) (time.Time, bool) {
+	seconds, ok := claim.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
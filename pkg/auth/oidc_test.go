@@ -0,0 +1,489 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClientID = "k8s-mcp-server"
+
+// testProvider is an OIDC provider serving discovery and a key set
+type testProvider struct {
+	server *httptest.Server
+	// keyFetches counts the requests for the key set
+	keyFetches atomic.Int32
+	// failing makes key set requests fail
+	failing atomic.Bool
+
+	mu   sync.Mutex
+	keys []jsonWebKey
+	// gate, if set, holds key set requests until it is closed, after
+	// signaling each on fetching
+	gate     chan struct{}
+	fetching chan struct{}
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	p := &testProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.server.URL,
+			"jwks_uri": p.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.keyFetches.Add(1)
+		p.mu.Lock()
+		gate, fetching := p.gate, p.fetching
+		p.mu.Unlock()
+		if gate != nil {
+			fetching <- struct{}{}
+			<-gate
+		}
+		if p.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": p.keys})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// setKeys replaces the key set served by the provider
+func (p *testProvider) setKeys(keys ...jsonWebKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+// authenticator returns an OIDCAuthenticator trusting the provider
+func (p *testProvider) authenticator(t *testing.T) *OIDCAuthenticator {
+	t.Helper()
+
+	a, err := NewOIDCAuthenticator(OIDCConfig{IssuerURL: p.server.URL, ClientID: testClientID})
+	if err != nil {
+		t.Fatalf("NewOIDCAuthenticator: %v", err)
+	}
+	return a
+}
+
+// claims returns valid claims issued by the provider
+func (p *testProvider) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":    p.server.URL,
+		"aud":    testClientID,
+		"sub":    "alice",
+		"groups": []string{"sre"},
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+	}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	return key
+}
+
+// publicJWK returns the JWK of the public half of an RSA or EC key
+func publicJWK(kid string, key crypto.Signer) jsonWebKey {
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(k.N), E: encode(big.NewInt(int64(k.E)))}
+	case *ecdsa.PrivateKey:
+		return jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256", X: encode(k.X), Y: encode(k.Y)}
+	}
+	panic("unsupported key type")
+}
+
+// signToken returns a JWT with the given header algorithm and key ID,
+// signed with key using SHA-256
+func signToken(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(claims)
+
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authenticate runs a request carrying the token through the authenticator
+func authenticate(a Authenticator, token string) (*Identity, error) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(r)
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	p := newTestProvider(t)
+	rsaKey, ecKey := newRSAKey(t), newECKey(t)
+	p.setKeys(publicJWK("rsa", rsaKey), publicJWK("ec", ecKey))
+	a := p.authenticator(t)
+
+	for _, tc := range []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+		kid  string
+	}{
+		{name: "RSA", key: rsaKey, alg: "RS256", kid: "rsa"},
+		{name: "EC", key: ecKey, alg: "ES256", kid: "ec"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, err := authenticate(a, signToken(t, tc.key, tc.alg, tc.kid, p.claims()))
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Username != "alice" || identity.Method != "oidc" ||
+				len(identity.Groups) != 1 || identity.Groups[0] != "sre" {
+				t.Errorf("got identity %+v, want alice in sre through oidc", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCAlgorithmMismatch(t *testing.T) {
+	p := newTestProvider(t)
+	rsaKey, ecKey := newRSAKey(t), newECKey(t)
+	p.setKeys(publicJWK("rsa", rsaKey), publicJWK("ec", ecKey))
+	a := p.authenticator(t)
+
+	for _, tc := range []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+		kid  string
+		want string
+	}{
+		{name: "ES256 header on RSA key", key: rsaKey, alg: "ES256", kid: "rsa", want: "does not match algorithm"},
+		{name: "RS256 header on EC key", key: ecKey, alg: "RS256", kid: "ec", want: "does not match algorithm"},
+		{name: "HS256", key: rsaKey, alg: "HS256", kid: "rsa", want: "unsupported signing algorithm"},
+		{name: "none", key: rsaKey, alg: "none", kid: "rsa", want: "unsupported signing algorithm"},
+		{name: "wrong key", key: newRSAKey(t), alg: "RS256", kid: "rsa", want: "invalid signature"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := authenticate(a, signToken(t, tc.key, tc.alg, tc.kid, p.claims()))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestOIDCTokenWithoutKeyID(t *testing.T) {
+	p := newTestProvider(t)
+	key := newRSAKey(t)
+
+	p.setKeys(publicJWK("only", key))
+	if _, err := authenticate(p.authenticator(t), signToken(t, key, "RS256", "", p.claims())); err != nil {
+		t.Errorf("token without kid and a single key: %v", err)
+	}
+
+	p.setKeys(publicJWK("first", key), publicJWK("second", newRSAKey(t)))
+	_, err := authenticate(p.authenticator(t), signToken(t, key, "RS256", "", p.claims()))
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Errorf("token without kid and several keys: got error %v, want unknown signing key", err)
+	}
+}
+
+func TestOIDCAudience(t *testing.T) {
+	p := newTestProvider(t)
+	key := newRSAKey(t)
+	p.setKeys(publicJWK("rsa", key))
+	a := p.authenticator(t)
+
+	for _, tc := range []struct {
+		name string
+		aud  interface{}
+		ok   bool
+	}{
+		{name: "string", aud: testClientID, ok: true},
+		{name: "array including client", aud: []string{"other", testClientID}, ok: true},
+		{name: "array without client", aud: []string{"other", "another"}},
+		{name: "other string", aud: "other"},
+		{name: "empty array", aud: []string{}},
+		{name: "missing", aud: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := p.claims()
+			if tc.aud == nil {
+				delete(claims, "aud")
+			} else {
+				claims["aud"] = tc.aud
+			}
+
+			_, err := authenticate(a, signToken(t, key, "RS256", "rsa", claims))
+			if tc.ok && err != nil {
+				t.Errorf("Authenticate: %v", err)
+			}
+			if !tc.ok && (err == nil || !strings.Contains(err.Error(), "audience")) {
+				t.Errorf("got error %v, want an audience error", err)
+			}
+		})
+	}
+}
+
+func TestOIDCValidityPeriod(t *testing.T) {
+	p := newTestProvider(t)
+	key := newRSAKey(t)
+	p.setKeys(publicJWK("rsa", key))
+	a := p.authenticator(t)
+
+	now := time.Now()
+	for _, tc := range []struct {
+		name string
+		exp  interface{}
+		nbf  interface{}
+		want string
+	}{
+		{name: "expired within skew", exp: now.Add(-clockSkew / 2).Unix()},
+		{name: "expired beyond skew", exp: now.Add(-2 * clockSkew).Unix(), want: "expired at"},
+		{name: "not yet valid within skew", exp: now.Add(time.Hour).Unix(), nbf: now.Add(clockSkew / 2).Unix()},
+		{name: "not yet valid beyond skew", exp: now.Add(time.Hour).Unix(), nbf: now.Add(2 * clockSkew).Unix(), want: "not valid before"},
+		{name: "missing expiry", want: "missing expiry"},
+		{name: "non-numeric expiry", exp: "tomorrow", want: "missing expiry"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := p.claims()
+			delete(claims, "exp")
+			if tc.exp != nil {
+				claims["exp"] = tc.exp
+			}
+			if tc.nbf != nil {
+				claims["nbf"] = tc.nbf
+			}
+
+			_, err := authenticate(a, signToken(t, key, "RS256", "rsa", claims))
+			if tc.want == "" && err != nil {
+				t.Errorf("Authenticate: %v", err)
+			}
+			if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRefetchIsRateLimited(t *testing.T) {
+	p := newTestProvider(t)
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	p.setKeys(publicJWK("old", oldKey))
+	a := p.authenticator(t)
+
+	if _, err := authenticate(a, signToken(t, oldKey, "RS256", "old", p.claims())); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got := p.keyFetches.Load(); got != 1 {
+		t.Fatalf("key set fetched %d times, want 1", got)
+	}
+
+	// The provider rotates its key; tokens signed with it are rejected
+	// without refetching until the refresh interval has passed
+	p.setKeys(publicJWK("old", oldKey), publicJWK("new", newKey))
+	for i := 0; i < 3; i++ {
+		_, err := authenticate(a, signToken(t, newKey, "RS256", "new", p.claims()))
+		if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+			t.Fatalf("got error %v, want unknown signing key", err)
+		}
+	}
+	if got := p.keyFetches.Load(); got != 1 {
+		t.Fatalf("key set fetched %d times within the refresh interval, want 1", got)
+	}
+
+	// Known keys are still served from the cache
+	if _, err := authenticate(a, signToken(t, oldKey, "RS256", "old", p.claims())); err != nil {
+		t.Fatalf("Authenticate with cached key: %v", err)
+	}
+
+	a.mu.Lock()
+	a.lastFetched = time.Now().Add(-minKeyRefreshInterval)
+	a.mu.Unlock()
+
+	if _, err := authenticate(a, signToken(t, newKey, "RS256", "new", p.claims())); err != nil {
+		t.Fatalf("Authenticate after the refresh interval: %v", err)
+	}
+	if got := p.keyFetches.Load(); got != 2 {
+		t.Errorf("key set fetched %d times, want 2", got)
+	}
+}
+
+func TestOIDCKeyFetchFailureBacksOff(t *testing.T) {
+	p := newTestProvider(t)
+	key := newRSAKey(t)
+	p.setKeys(publicJWK("rsa", key))
+	a := p.authenticator(t)
+	token := signToken(t, key, "RS256", "rsa", p.claims())
+
+	p.failing.Store(true)
+	if _, err := authenticate(a, token); err == nil || !strings.Contains(err.Error(), "failed to fetch OIDC keys") {
+		t.Fatalf("got error %v, want a key fetch failure", err)
+	}
+
+	// Failures are retried after a short backoff, not hammered
+	if _, err := authenticate(a, token); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("got error %v within the backoff, want unknown signing key", err)
+	}
+	if got := p.keyFetches.Load(); got != 1 {
+		t.Fatalf("key set fetched %d times within the backoff, want 1", got)
+	}
+
+	// nor do they count as a fetch delaying the next one by the refresh
+	// interval
+	p.failing.Store(false)
+	a.mu.Lock()
+	if !a.lastFetched.IsZero() {
+		t.Error("failed fetch was recorded as the last fetch")
+	}
+	a.retryAfter = time.Now().Add(-time.Second)
+	a.mu.Unlock()
+
+	if _, err := authenticate(a, token); err != nil {
+		t.Fatalf("Authenticate after the backoff: %v", err)
+	}
+	if got := p.keyFetches.Load(); got != 2 {
+		t.Errorf("key set fetched %d times, want 2", got)
+	}
+}
+
+func TestOIDCKeyFetchOutlivesRequest(t *testing.T) {
+	p := newTestProvider(t)
+	key := newRSAKey(t)
+	p.setKeys(publicJWK("rsa", key))
+	a := p.authenticator(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, key, "RS256", "rsa", p.claims()))
+
+	if _, err := a.Authenticate(r); err != nil {
+		t.Errorf("Authenticate with a canceled request: %v", err)
+	}
+}
+
+func TestOIDCCachedKeysDuringFetch(t *testing.T) {
+	p := newTestProvider(t)
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	p.setKeys(publicJWK("old", oldKey))
+	a := p.authenticator(t)
+
+	oldToken := signToken(t, oldKey, "RS256", "old", p.claims())
+	if _, err := authenticate(a, oldToken); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// Hold the next fetch, started by a token signed with a rotated key
+	p.setKeys(publicJWK("old", oldKey), publicJWK("new", newKey))
+	gate := make(chan struct{})
+	p.mu.Lock()
+	p.gate, p.fetching = gate, make(chan struct{}, 1)
+	p.mu.Unlock()
+	a.mu.Lock()
+	a.lastFetched = time.Now().Add(-minKeyRefreshInterval)
+	a.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := authenticate(a, signToken(t, newKey, "RS256", "new", p.claims()))
+		done <- err
+	}()
+	<-p.fetching
+
+	cached := make(chan error, 1)
+	go func() {
+		_, err := authenticate(a, oldToken)
+		cached <- err
+	}()
+	select {
+	case err := <-cached:
+		if err != nil {
+			t.Errorf("Authenticate with a cached key during a fetch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Authenticate with a cached key blocked on the key set fetch")
+	}
+
+	close(gate)
+	if err := <-done; err != nil {
+		t.Errorf("Authenticate with the rotated key: %v", err)
+	}
+}
+
+func TestOIDCIgnoresOtherCredentials(t *testing.T) {
+	p := newTestProvider(t)
+	a := p.authenticator(t)
+
+	if _, err := authenticate(a, "static-token"); err != ErrNoCredentials {
+		t.Errorf("got error %v for a non-JWT token, want ErrNoCredentials", err)
+	}
+	if got := p.keyFetches.Load(); got != 0 {
+		t.Errorf("key set fetched %d times for a non-JWT token, want 0", got)
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// errUnknownToken rejects bearer tokens missing from the token file
+var errUnknownToken = errors.New("unknown bearer token")
+
+// TokenAuthenticator authenticates static bearer tokens
+type TokenAuthenticator struct {
+	// tokens maps the SHA-256 of each token to its identity, so lookups do
+	// not leak token contents through timing
+	tokens map[[sha256.Size]byte]*Identity
+}
+
+// LoadTokenFile reads static tokens from a CSV file in the format of the
+// Kubernetes API server's --token-auth-file: one token,user,uid line per
+// token, optionally followed by a quoted, comma separated list of groups.
+// Blank lines and lines starting with # are ignored.
+func LoadTokenFile(path string) (*TokenAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]*Identity)}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token file %s: %v", path, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("token file %s line %d: expected token,user[,uid[,groups]]", path, line)
+		}
+
+		identity := &Identity{Username: record[1], Method: "token"}
+		if len(record) > 3 && record[3] != "" {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					identity.Groups = append(identity.Groups, group)
+				}
+			}
+		}
+
+		key := sha256.Sum256([]byte(record[0]))
+		if _, ok := a.tokens[key]; ok {
+			return nil, fmt.Errorf("token file %s line %d: duplicate token", path, line)
+		}
+		a.tokens[key] = identity
+	}
+
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	identity, ok := a.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, errUnknownToken
+	}
+	return identity, nil
+}
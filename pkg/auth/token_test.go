@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTokenFile writes a token file to a temporary directory
+func writeTokenFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	return path
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := writeTokenFile(t, `# static tokens
+token-a,alice,1001,"sre, admins"
+
+token-b,bob,1002
+token-c,ci-bot
+`)
+	a, err := LoadTokenFile(path)
+	if err != nil {
+		t.Fatalf("LoadTokenFile: %v", err)
+	}
+
+	for _, tc := range []struct {
+		token string
+		want  *Identity
+	}{
+		{token: "token-a", want: &Identity{Username: "alice", Groups: []string{"sre", "admins"}, Method: "token"}},
+		{token: "token-b", want: &Identity{Username: "bob", Method: "token"}},
+		{token: "token-c", want: &Identity{Username: "ci-bot", Method: "token"}},
+	} {
+		identity, err := authenticate(a, tc.token)
+		if err != nil {
+			t.Errorf("Authenticate(%s): %v", tc.token, err)
+			continue
+		}
+		if !reflect.DeepEqual(identity, tc.want) {
+			t.Errorf("Authenticate(%s) = %+v, want %+v", tc.token, identity, tc.want)
+		}
+	}
+
+	if _, err := authenticate(a, "token-d"); err != errUnknownToken {
+		t.Errorf("got error %v for an unknown token, want errUnknownToken", err)
+	}
+}
+
+func TestLoadTokenFileRejectsDuplicateTokens(t *testing.T) {
+	path := writeTokenFile(t, "token-a,alice\ntoken-b,bob\ntoken-a,mallory,1003,admins\n")
+
+	_, err := LoadTokenFile(path)
+	if err == nil || !strings.Contains(err.Error(), "line 3: duplicate token") {
+		t.Errorf("got error %v, want a duplicate token on line 3", err)
+	}
+}
+
+func TestLoadTokenFileRejectsInvalidLines(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "token only", content: "token-a\n", want: "line 1: expected token,user"},
+		{name: "empty user", content: "token-a,alice\ntoken-b,\n", want: "line 2: expected token,user"},
+		{name: "empty token", content: ",alice\n", want: "line 1: expected token,user"},
+		{name: "unterminated quote", content: "token-a,alice,1001,\"sre\n", want: "failed to parse token file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadTokenFile(writeTokenFile(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadTokenFileMissing(t *testing.T) {
+	if _, err := LoadTokenFile(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("LoadTokenFile succeeded for a missing file")
+	}
+}
+
+func TestTokenAuthenticatorWithoutBearerToken(t *testing.T) {
+	a, err := LoadTokenFile(writeTokenFile(t, "token-a,alice\n"))
+	if err != nil {
+		t.Fatalf("LoadTokenFile: %v", err)
+	}
+
+	if _, err := a.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrNoCredentials {
+		t.Errorf("got error %v without a bearer token, want ErrNoCredentials", err)
+	}
+}
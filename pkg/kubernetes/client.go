@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"sync"
 
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -19,10 +21,22 @@ import (
 type Client struct {
 	clientset     *kubernetes.Clientset
 	dynamicClient dynamic.Interface
+	resolver      *resourceResolver
+	redactor      *redact.Redactor
+
+	// When impersonate is set, requests are made as the caller through
+	// clients built from config and cached by user and groups
+	config       *rest.Config
+	impersonate  bool
+	mu           sync.Mutex
+	impersonated map[string]*impersonatedClients
 }
 
-// NewClient creates a new Kubernetes client
-func NewClient(kubeconfigPath string) (*Client, error) {
+// NewClient creates a new Kubernetes client. With impersonate set, every
+// request is made on behalf of the authenticated caller in its context
+// using Kubernetes impersonation, so cluster RBAC decides what the caller
+// may do; resource discovery still uses the server's own identity.
+func NewClient(kubeconfigPath string, impersonate bool) (*Client, error) {
 	var config *rest.Config
 	var err error
 
@@ -58,6 +72,10 @@ func NewClient(kubeconfigPath string) (*Client, error) {
 	return &Client{
 		clientset:     clientset,
 		dynamicClient: dynamicClient,
+		resolver:      newResourceResolver(clientset.Discovery()),
+		config:        config,
+		impersonate:   impersonate,
+		impersonated:  make(map[string]*impersonatedClients),
 	}, nil
 }
 
@@ -66,111 +84,206 @@ func (c *Client) GetClientset() *kubernetes.Clientset {
 	return c.clientset
 }
 
+// SetRedactor sets the redactor applied to every object the client returns
+func (c *Client) SetRedactor(r *redact.Redactor) {
+	c.redactor = r
+}
+
 // GetResource retrieves a specific resource by name
-func (c *Client) GetResource(resourceType, namespace, name string) (*unstructured.Unstructured, error) {
-	gvr, err := getGroupVersionResource(resourceType)
+func (c *Client) GetResource(ctx context.Context, resourceType, namespace, name string) (*unstructured.Unstructured, error) {
+	info, err := c.ResolveResource(resourceType)
 	if err != nil {
 		return nil, err
 	}
 
-	var resource *unstructured.Unstructured
-	if namespace != "" {
-		resource, err = c.dynamicClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-	} else {
-		resource, err = c.dynamicClient.Resource(gvr).Get(context.TODO(), name, metav1.GetOptions{})
+	client, err := c.resourceInterface(ctx, info, objectNamespace(info, namespace))
+	if err != nil {
+		return nil, err
 	}
 
+	resource, err := client.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get %s '%s': %v", resourceType, name, err)
+		return nil, fmt.Errorf("failed to get %s '%s': %w", resourceType, name, err)
 	}
 
+	c.redactor.Object(ctx, resource.Object)
 	return resource, nil
 }
 
-// ListResources lists resources of a specific type
-func (c *Client) ListResources(resourceType, namespace string) (*unstructured.UnstructuredList, error) {
-	gvr, err := getGroupVersionResource(resourceType)
+// ListResources lists resources of a specific type. Namespaced resources are
+// listed across all namespaces when namespace is empty. The options carry
+// label and field selectors and the limit and continue token used to page
+// through large lists.
+func (c *Client) ListResources(ctx context.Context, resourceType, namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	info, err := c.ResolveResource(resourceType)
 	if err != nil {
 		return nil, err
 	}
 
-	var resources *unstructured.UnstructuredList
-	if namespace != "" {
-		resources, err = c.dynamicClient.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
-	} else {
-		resources, err = c.dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{})
+	client, err := c.resourceInterface(ctx, info, namespace)
+	if err != nil {
+		return nil, err
 	}
 
+	resources, err := client.List(ctx, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list %s: %v", resourceType, err)
+		return nil, fmt.Errorf("failed to list %s: %w", resourceType, err)
 	}
 
+	for i := range resources.Items {
+		c.redactor.Object(ctx, resources.Items[i].Object)
+	}
 	return resources, nil
 }
 
-// CreateResource creates a new resource
-func (c *Client) CreateResource(resourceType, namespace string, object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
-	gvr, err := getGroupVersionResource(resourceType)
+// CreateResource creates a new resource. Namespaced resources are created
+// in the given namespace, else the one in the object's metadata, else the
+// default namespace. With dryRun set the API server validates the object
+// and returns it as it would be persisted, without persisting it.
+func (c *Client) CreateResource(ctx context.Context, resourceType, namespace string, object *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	info, err := c.ResolveResource(resourceType)
 	if err != nil {
 		return nil, err
 	}
 
-	var created *unstructured.Unstructured
-	if namespace != "" {
-		created, err = c.dynamicClient.Resource(gvr).Namespace(namespace).Create(context.TODO(), object, metav1.CreateOptions{})
-	} else {
-		created, err = c.dynamicClient.Resource(gvr).Create(context.TODO(), object, metav1.CreateOptions{})
+	if namespace == "" {
+		namespace = object.GetNamespace()
+	}
+	namespace = objectNamespace(info, namespace)
+	if info.Namespaced {
+		object.SetNamespace(namespace)
+	}
+
+	client, err := c.resourceInterface(ctx, info, namespace)
+	if err != nil {
+		return nil, err
 	}
 
+	created, err := client.Create(ctx, object, metav1.CreateOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create %s: %v", resourceType, err)
+		return nil, fmt.Errorf("failed to create %s: %w", resourceType, err)
 	}
 
+	c.redactor.Object(ctx, created.Object)
 	return created, nil
 }
 
-// DeleteResource deletes a resource
-func (c *Client) DeleteResource(resourceType, namespace, name string) error {
-	gvr, err := getGroupVersionResource(resourceType)
+// UpdateResource replaces an existing resource with the given object. The
+// object must carry the resourceVersion it was read at; a stale version is
+// reported as a ConflictError. With dryRun set the update is validated
+// but not persisted.
+func (c *Client) UpdateResource(ctx context.Context, resourceType, namespace string, object *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	info, err := c.ResolveResource(resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = object.GetNamespace()
+	}
+	namespace = objectNamespace(info, namespace)
+	if info.Namespaced {
+		object.SetNamespace(namespace)
+	}
+
+	client, err := c.resourceInterface(ctx, info, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := client.Update(ctx, object, metav1.UpdateOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return nil, writeError("update", resourceType, object.GetName(), err)
+	}
+
+	c.redactor.Object(ctx, updated.Object)
+	return updated, nil
+}
+
+// PatchResource patches an existing resource with a JSON patch, merge patch
+// or strategic merge patch document. With dryRun set the patch is
+// validated but not persisted.
+func (c *Client) PatchResource(ctx context.Context, resourceType, namespace, name string, patchType types.PatchType, patch []byte, dryRun bool) (*unstructured.Unstructured, error) {
+	info, err := c.ResolveResource(resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := c.resourceInterface(ctx, info, objectNamespace(info, namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := client.Patch(ctx, name, patchType, patch, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		return nil, writeError("patch", resourceType, name, err)
+	}
+
+	c.redactor.Object(ctx, patched.Object)
+	return patched, nil
+}
+
+// ApplyResource applies an object with server-side apply on behalf of the
+// given field manager. Fields owned by other managers are reported as a
+// ConflictError unless force is set. With dryRun set the apply is
+// validated but not persisted.
+func (c *Client) ApplyResource(ctx context.Context, resourceType, namespace string, object *unstructured.Unstructured, fieldManager string, force, dryRun bool) (*unstructured.Unstructured, error) {
+	info, err := c.ResolveResource(resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = object.GetNamespace()
+	}
+	namespace = objectNamespace(info, namespace)
+	if info.Namespaced {
+		object.SetNamespace(namespace)
+	}
+
+	client, err := c.resourceInterface(ctx, info, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := client.Apply(ctx, object.GetName(), object, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        force,
+		DryRun:       dryRunOption(dryRun),
+	})
+	if err != nil {
+		return nil, writeError("apply", resourceType, object.GetName(), err)
+	}
+
+	c.redactor.Object(ctx, applied.Object)
+	return applied, nil
+}
+
+// DeleteResource deletes a resource. With dryRun set the API server
+// validates the delete without persisting it.
+func (c *Client) DeleteResource(ctx context.Context, resourceType, namespace, name string, dryRun bool) error {
+	info, err := c.ResolveResource(resourceType)
 	if err != nil {
 		return err
 	}
 
-	var deleteErr error
-	if namespace != "" {
-		deleteErr = c.dynamicClient.Resource(gvr).Namespace(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
-	} else {
-		deleteErr = c.dynamicClient.Resource(gvr).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	client, err := c.resourceInterface(ctx, info, objectNamespace(info, namespace))
+	if err != nil {
+		return err
 	}
 
-	if deleteErr != nil {
-		return fmt.Errorf("failed to delete %s '%s': %v", resourceType, name, deleteErr)
+	if err := client.Delete(ctx, name, metav1.DeleteOptions{DryRun: dryRunOption(dryRun)}); err != nil {
+		return fmt.Errorf("failed to delete %s '%s': %w", resourceType, name, err)
 	}
 
 	return nil
 }
 
-// getGroupVersionResource maps a resource type to its GroupVersionResource
-func getGroupVersionResource(resourceType string) (schema.GroupVersionResource, error) {
-	// Map of common resource types to their GroupVersionResource
-	resourceMap := map[string]schema.GroupVersionResource{
-		"pods":                   {Group: "", Version: "v1", Resource: "pods"},
-		"services":               {Group: "", Version: "v1", Resource: "services"},
-		"deployments":            {Group: "apps", Version: "v1", Resource: "deployments"},
-		"namespaces":             {Group: "", Version: "v1", Resource: "namespaces"},
-		"configmaps":             {Group: "", Version: "v1", Resource: "configmaps"},
-		"secrets":                {Group: "", Version: "v1", Resource: "secrets"},
-		"persistentvolumes":      {Group: "", Version: "v1", Resource: "persistentvolumes"},
-		"persistentvolumeclaims": {Group: "", Version: "v1", Resource: "persistentvolumeclaims"},
-		"statefulsets":           {Group: "apps", Version: "v1", Resource: "statefulsets"},
-		"daemonsets":             {Group: "apps", Version: "v1", Resource: "daemonsets"},
-		"ingresses":              {Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
-	}
-
-	gvr, exists := resourceMap[resourceType]
-	if !exists {
-		return schema.GroupVersionResource{}, fmt.Errorf("unsupported resource type: %s", resourceType)
-	}
-
-	return gvr, nil
+// dryRunOption returns the DryRun option of write requests: All to have
+// every stage of the request run without persisting it, or none
+func dryRunOption(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
 }
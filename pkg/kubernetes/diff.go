@@ -0,0 +1,161 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ignoredDiffPaths are bookkeeping fields the API server changes on every
+// write, left out of diffs
+var ignoredDiffPaths = map[string]bool{
+	"metadata.resourceVersion": true,
+	"metadata.generation":      true,
+	"metadata.managedFields":   true,
+}
+
+// plainKey matches map keys that can be written as .key in a path
+var plainKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FieldChange is a single difference between two versions of an object
+type FieldChange struct {
+	// Path locates the field, e.g. spec.template.spec.containers[0].image
+	// or metadata.labels["app.kubernetes.io/name"]
+	Path string `json:"path"`
+	// Operation is add, remove or replace
+	Operation string      `json:"op"`
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+}
+
+// DiffObjects returns the fields that differ between the unredacted live
+// version of an object and an updated one, in document order with keys
+// sorted; lists are compared element by element. The old and new values are
+// reported as the redactor would return them, so a sensitive value that
+// changed is reported as replaced even when both versions are masked the
+// same way.
+func (c *Client) DiffObjects(ctx context.Context, live, updated *unstructured.Unstructured) []FieldChange {
+	// The values shown are counted when the objects themselves are redacted
+	ctx, _ = redact.WithCounter(ctx)
+	liveShown, updatedShown := live.DeepCopy(), updated.DeepCopy()
+	c.redactor.Object(ctx, liveShown.Object)
+	c.redactor.Object(ctx, updatedShown.Object)
+
+	var changes []FieldChange
+	diffValues("", live.Object, updated.Object, liveShown.Object, updatedShown.Object, &changes)
+	return changes
+}
+
+// RedactObject masks the sensitive values of an object read or written
+// under redact.WithoutRedaction before it is returned
+func (c *Client) RedactObject(ctx context.Context, object *unstructured.Unstructured) {
+	if object != nil {
+		c.redactor.Object(ctx, object.Object)
+	}
+}
+
+// diffValues appends the differences between old and new at path. Values
+// are compared as given and reported as shown, their redacted counterparts.
+func diffValues(path string, old, new, oldShown, newShown interface{}, changes *[]FieldChange) {
+	if ignoredDiffPaths[path] {
+		return
+	}
+
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		if newValue, ok := new.(map[string]interface{}); ok {
+			diffMaps(path, oldValue, newValue, oldShown, newShown, changes)
+			return
+		}
+	case []interface{}:
+		if newValue, ok := new.([]interface{}); ok {
+			diffLists(path, oldValue, newValue, oldShown, newShown, changes)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, FieldChange{Path: path, Operation: "replace", Old: oldShown, New: newShown})
+	}
+}
+
+// diffMaps compares two objects key by key
+func diffMaps(path string, old, new map[string]interface{}, oldShown, newShown interface{}, changes *[]FieldChange) {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fieldPath := childPath(path, key)
+		if ignoredDiffPaths[fieldPath] {
+			continue
+		}
+
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		switch {
+		case !inOld:
+			*changes = append(*changes, FieldChange{Path: fieldPath, Operation: "add", New: shownField(newShown, key)})
+		case !inNew:
+			*changes = append(*changes, FieldChange{Path: fieldPath, Operation: "remove", Old: shownField(oldShown, key)})
+		default:
+			diffValues(fieldPath, oldValue, newValue, shownField(oldShown, key), shownField(newShown, key), changes)
+		}
+	}
+}
+
+// diffLists compares two lists element by element
+func diffLists(path string, old, new []interface{}, oldShown, newShown interface{}, changes *[]FieldChange) {
+	for i := 0; i < len(old) || i < len(new); i++ {
+		elementPath := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(old):
+			*changes = append(*changes, FieldChange{Path: elementPath, Operation: "add", New: shownElement(newShown, i)})
+		case i >= len(new):
+			*changes = append(*changes, FieldChange{Path: elementPath, Operation: "remove", Old: shownElement(oldShown, i)})
+		default:
+			diffValues(elementPath, old[i], new[i], shownElement(oldShown, i), shownElement(newShown, i), changes)
+		}
+	}
+}
+
+// shownField returns the field of a redacted map. Redaction only replaces
+// strings, so the map has the shape of the one compared.
+func shownField(shown interface{}, key string) interface{} {
+	if m, ok := shown.(map[string]interface{}); ok {
+		return m[key]
+	}
+	return nil
+}
+
+// shownElement returns the element of a redacted list
+func shownElement(shown interface{}, i int) interface{} {
+	if list, ok := shown.([]interface{}); ok && i < len(list) {
+		return list[i]
+	}
+	return nil
+}
+
+// childPath returns the path of a map key below path
+func childPath(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
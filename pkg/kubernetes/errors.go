@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fieldManagerPattern extracts the quoted manager name from the message of a
+// server-side apply conflict cause, e.g. `conflict with "kubectl" using apps/v1`
+var fieldManagerPattern = regexp.MustCompile(`^conflict with ("(?:[^"\\]|\\.)*")`)
+
+// ConflictError reports a write rejected by the API server because of a
+// conflict: either a stale resourceVersion or, for server-side apply, fields
+// owned by other field managers
+type ConflictError struct {
+	Operation string          `json:"operation"`
+	Resource  string          `json:"resource"`
+	Name      string          `json:"name,omitempty"`
+	Message   string          `json:"message"`
+	Conflicts []FieldConflict `json:"conflicts,omitempty"`
+}
+
+// FieldConflict is a single field owned by another field manager
+type FieldConflict struct {
+	Manager string `json:"manager"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("failed to %s %s '%s': %s", e.Operation, e.Resource, e.Name, e.Message)
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+// ErrorDetails returns the structured conflict description
+func (e *ConflictError) ErrorDetails() interface{} {
+	return e
+}
+
+// writeError wraps an error returned by a write operation. API server
+// conflicts are converted into a ConflictError.
+func writeError(operation, resource, name string, err error) error {
+	var statusErr *apierrors.StatusError
+	if !errors.As(err, &statusErr) || !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to %s %s '%s': %w", operation, resource, name, err)
+	}
+
+	status := statusErr.Status()
+	conflict := &ConflictError{
+		Operation: operation,
+		Resource:  resource,
+		Name:      name,
+		Message:   status.Message,
+	}
+
+	if status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type != metav1.CauseTypeFieldManagerConflict {
+				continue
+			}
+
+			manager := cause.Message
+			if match := fieldManagerPattern.FindStringSubmatch(cause.Message); match != nil {
+				if unquoted, err := strconv.Unquote(match[1]); err == nil {
+					manager = unquoted
+				}
+			}
+
+			conflict.Conflicts = append(conflict.Conflicts, FieldConflict{
+				Manager: manager,
+				Field:   cause.Field,
+				Message: cause.Message,
+			})
+		}
+	}
+
+	return conflict
+}
@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/auth"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// maxImpersonatedClients bounds the number of cached impersonating clients;
+// the cache is emptied when it is full
+const maxImpersonatedClients = 256
+
+// ImpersonationError reports that a request cannot be made on behalf of
+// its caller, either because there is no authenticated caller or because
+// the server is not allowed to impersonate it
+type ImpersonationError struct {
+	User    string   `json:"user,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Message string   `json:"message"`
+}
+
+// Error implements the error interface
+func (e *ImpersonationError) Error() string {
+	if e.User == "" {
+		return fmt.Sprintf("cannot impersonate caller: %s", e.Message)
+	}
+	return fmt.Sprintf("cannot impersonate user '%s': %s", e.User, e.Message)
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *ImpersonationError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// ErrorDetails returns the identity that could not be impersonated
+func (e *ImpersonationError) ErrorDetails() interface{} {
+	return e
+}
+
+// impersonatedClients are the clients acting as one user and group set
+type impersonatedClients struct {
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+}
+
+// ClientsetFor returns the clientset to serve a request with: one
+// impersonating the caller in ctx when impersonation is enabled, the
+// server's own otherwise
+func (c *Client) ClientsetFor(ctx context.Context) (kubernetes.Interface, error) {
+	clients, err := c.clientsFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return clients.clientset, nil
+}
+
+// dynamicClientFor returns the dynamic client to serve a request with, as
+// ClientsetFor does
+func (c *Client) dynamicClientFor(ctx context.Context) (dynamic.Interface, error) {
+	clients, err := c.clientsFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return clients.dynamicClient, nil
+}
+
+// clientsFor returns the clients acting as the caller in ctx, creating and
+// caching them on first use. Before caching, the server checks that it may
+// impersonate the caller, so a missing impersonate permission is reported
+// as an ImpersonationError rather than as a failure of the command.
+func (c *Client) clientsFor(ctx context.Context) (*impersonatedClients, error) {
+	if !c.impersonate {
+		return &impersonatedClients{clientset: c.clientset, dynamicClient: c.dynamicClient}, nil
+	}
+
+	identity, ok := auth.IdentityFrom(ctx)
+	if !ok {
+		return nil, &ImpersonationError{Message: "the request has no authenticated user"}
+	}
+
+	groups := append([]string(nil), identity.Groups...)
+	sort.Strings(groups)
+	key := identity.Username + "\x00" + strings.Join(groups, "\x00")
+
+	c.mu.Lock()
+	clients, ok := c.impersonated[key]
+	c.mu.Unlock()
+	if ok {
+		return clients, nil
+	}
+
+	if err := c.checkImpersonation(ctx, identity.Username, groups); err != nil {
+		return nil, err
+	}
+
+	config := rest.CopyConfig(c.config)
+	config.Impersonate = rest.ImpersonationConfig{UserName: identity.Username, Groups: groups}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating clientset: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating dynamic client: %v", err)
+	}
+	clients = &impersonatedClients{clientset: clientset, dynamicClient: dynamicClient}
+
+	c.mu.Lock()
+	if len(c.impersonated) >= maxImpersonatedClients {
+		c.impersonated = make(map[string]*impersonatedClients)
+	}
+	c.impersonated[key] = clients
+	c.mu.Unlock()
+
+	return clients, nil
+}
+
+// checkImpersonation asks the API server whether the server's own identity
+// may impersonate the user and each of the groups
+func (c *Client) checkImpersonation(ctx context.Context, user string, groups []string) error {
+	check := func(resource, name string) error {
+		review, err := c.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     "impersonate",
+					Resource: resource,
+					Name:     name,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to check impersonation permission: %w", err)
+		}
+		if !review.Status.Allowed {
+			return &ImpersonationError{
+				User:    user,
+				Groups:  groups,
+				Message: fmt.Sprintf("the server is not allowed to impersonate %s '%s'; grant its identity the impersonate verb on %s", strings.TrimSuffix(resource, "s"), name, resource),
+			}
+		}
+		return nil
+	}
+
+	if err := check("users", user); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := check("groups", group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// minDiscoveryRefreshInterval limits how often a lookup miss may trigger a
+// fresh round of API discovery, so typos cannot hammer the API server
+const minDiscoveryRefreshInterval = 10 * time.Second
+
+// ResourceInfo describes a resolved Kubernetes resource type
+type ResourceInfo struct {
+	GroupVersionResource schema.GroupVersionResource `json:"groupVersionResource"`
+	Kind                 string                      `json:"kind"`
+	Namespaced           bool                        `json:"namespaced"`
+}
+
+// resourceResolver maps user supplied resource names to API resources using
+// a RESTMapper backed by cached discovery
+type resourceResolver struct {
+	mapper meta.ResettableRESTMapper
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+// newResourceResolver creates a resolver that discovers resources lazily and
+// understands kubectl-style short names
+func newResourceResolver(discoveryClient discovery.DiscoveryInterface) *resourceResolver {
+	cached := memory.NewMemCacheClient(discoveryClient)
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDeferredDiscoveryRESTMapper(cached), cached, nil)
+
+	return &resourceResolver{
+		mapper: mapper.(meta.ResettableRESTMapper),
+	}
+}
+
+// resolve looks up a resource by plural, singular, kind, short name or a
+// fully qualified resource.group / resource.version.group form. A miss
+// refreshes discovery once, so newly installed CRDs are picked up.
+func (r *resourceResolver) resolve(resourceType string) (*ResourceInfo, error) {
+	mapping, err := r.mapping(resourceType)
+	if err != nil && meta.IsNoMatchError(err) && r.refresh() {
+		mapping, err = r.mapping(resourceType)
+	}
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("unsupported resource type: %s", resourceType)
+		}
+		return nil, fmt.Errorf("failed to resolve resource type %s: %v", resourceType, err)
+	}
+
+	return &ResourceInfo{
+		GroupVersionResource: mapping.Resource,
+		Kind:                 mapping.GroupVersionKind.Kind,
+		Namespaced:           mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+	}, nil
+}
+
+// mapping resolves a resource argument the same way kubectl does
+func (r *resourceResolver) mapping(resourceType string) (*meta.RESTMapping, error) {
+	arg := strings.ToLower(strings.TrimSpace(resourceType))
+	if arg == "" {
+		return nil, fmt.Errorf("resource type is required")
+	}
+
+	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(arg)
+
+	var gvk schema.GroupVersionKind
+	if fullySpecifiedGVR != nil {
+		gvk, _ = r.mapper.KindFor(*fullySpecifiedGVR)
+	}
+	if gvk.Empty() {
+		var err error
+		gvk, err = r.mapper.KindFor(groupResource.WithVersion(""))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// refresh drops the discovery cache unless it was refreshed recently, and
+// reports whether it did
+func (r *resourceResolver) refresh() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastRefresh) < minDiscoveryRefreshInterval {
+		return false
+	}
+	r.lastRefresh = time.Now()
+	r.mapper.Reset()
+	return true
+}
+
+// ResolveResource resolves a resource type to its API resource and scope
+func (c *Client) ResolveResource(resourceType string) (*ResourceInfo, error) {
+	return c.resolver.resolve(resourceType)
+}
+
+// resourceInterface returns the dynamic client for a resource, acting as
+// the caller in ctx when impersonating. Namespaced resources are scoped to
+// the namespace, where an empty namespace means all namespaces; the
+// namespace is ignored for cluster-scoped resources.
+func (c *Client) resourceInterface(ctx context.Context, info *ResourceInfo, namespace string) (dynamic.ResourceInterface, error) {
+	dynamicClient, err := c.dynamicClientFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Namespaced {
+		return dynamicClient.Resource(info.GroupVersionResource).Namespace(namespace), nil
+	}
+	return dynamicClient.Resource(info.GroupVersionResource), nil
+}
+
+// objectNamespace returns the namespace to use for operations on a single
+// object, defaulting namespaced resources to the default namespace
+func objectNamespace(info *ResourceInfo, namespace string) string {
+	if info.Namespaced && namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
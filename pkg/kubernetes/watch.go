@@ -0,0 +1,229 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// watchRestartBackoff is the pause before re-establishing a watch that the
+// server closed without delivering any event
+const watchRestartBackoff = time.Second
+
+// Watch event types delivered to callers
+const (
+	WatchAdded    = string(watch.Added)
+	WatchModified = string(watch.Modified)
+	WatchDeleted  = string(watch.Deleted)
+)
+
+// WatchEvent is a single change to a watched resource
+type WatchEvent struct {
+	Type   string                     `json:"type"`
+	Object *unstructured.Unstructured `json:"object"`
+}
+
+// WatchResources watches resources of a type and calls fn for every
+// ADDED, MODIFIED or DELETED event until ctx is done or fn returns an error.
+// The options carry label and field selectors and the resourceVersion to
+// start from; without a resourceVersion every existing object is first
+// delivered as ADDED.
+//
+// Watches closed by the server are resumed from the last seen
+// resourceVersion. When that version has expired (410 Gone) the resources
+// are relisted and the differences since the last seen state are delivered
+// as synthetic events before watching resumes, so callers never observe the
+// gap.
+func (c *Client) WatchResources(ctx context.Context, resourceType, namespace string, opts metav1.ListOptions, fn func(WatchEvent) error) error {
+	info, err := c.ResolveResource(resourceType)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.resourceInterface(ctx, info, namespace)
+	if err != nil {
+		return err
+	}
+
+	w := &resourceWatcher{
+		client:          client,
+		opts:            opts,
+		resourceVersion: opts.ResourceVersion,
+		known:           make(map[string]string),
+		fn: func(event WatchEvent) error {
+			c.redactor.Object(ctx, event.Object.Object)
+			return fn(event)
+		},
+	}
+
+	if err := w.run(ctx); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", resourceType, err)
+	}
+	return nil
+}
+
+// resourceWatcher holds the state of a resumable watch
+type resourceWatcher struct {
+	client          dynamic.ResourceInterface
+	opts            metav1.ListOptions
+	resourceVersion string
+	// known maps the namespace/name of every object seen to its last
+	// resourceVersion, used to compute differences after a relist
+	known map[string]string
+	fn    func(WatchEvent) error
+}
+
+// run watches until ctx is done or the callback fails
+func (w *resourceWatcher) run(ctx context.Context) error {
+	for {
+		delivered, err := w.watchOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			if !apierrors.IsResourceExpired(err) && !apierrors.IsGone(err) {
+				return err
+			}
+			if err := w.relist(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		if !delivered {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(watchRestartBackoff):
+			}
+		}
+	}
+}
+
+// watchOnce runs a single watch request until the server closes it. It
+// reports whether any event was delivered.
+func (w *resourceWatcher) watchOnce(ctx context.Context) (bool, error) {
+	opts := w.opts
+	opts.ResourceVersion = w.resourceVersion
+	opts.AllowWatchBookmarks = true
+
+	watcher, err := w.client.Watch(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	defer watcher.Stop()
+
+	delivered := false
+	for {
+		select {
+		case <-ctx.Done():
+			return delivered, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return delivered, nil
+			}
+
+			switch event.Type {
+			case watch.Error:
+				return delivered, apierrors.FromObject(event.Object)
+			case watch.Bookmark:
+				if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+					w.resourceVersion = obj.GetResourceVersion()
+				}
+			case watch.Added, watch.Modified, watch.Deleted:
+				obj, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				delivered = true
+				if err := w.deliver(string(event.Type), obj); err != nil {
+					return delivered, err
+				}
+			}
+		}
+	}
+}
+
+// relist lists the resources afresh and delivers the differences from the
+// last known state as synthetic events
+func (w *resourceWatcher) relist(ctx context.Context) error {
+	list, err := w.client.List(ctx, metav1.ListOptions{
+		LabelSelector: w.opts.LabelSelector,
+		FieldSelector: w.opts.FieldSelector,
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		obj := &list.Items[i]
+		key := objectKey(obj)
+		seen[key] = true
+
+		previous, exists := w.known[key]
+		switch {
+		case !exists:
+			if err := w.deliver(WatchAdded, obj); err != nil {
+				return err
+			}
+		case previous != obj.GetResourceVersion():
+			if err := w.deliver(WatchModified, obj); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key := range w.known {
+		if seen[key] {
+			continue
+		}
+		namespace, name := splitObjectKey(key)
+		obj := &unstructured.Unstructured{}
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		if err := w.deliver(WatchDeleted, obj); err != nil {
+			return err
+		}
+	}
+
+	w.resourceVersion = list.GetResourceVersion()
+	return nil
+}
+
+// deliver records the event in the known state and passes it to the callback
+func (w *resourceWatcher) deliver(eventType string, obj *unstructured.Unstructured) error {
+	key := objectKey(obj)
+	if eventType == WatchDeleted {
+		delete(w.known, key)
+	} else {
+		w.known[key] = obj.GetResourceVersion()
+	}
+	if rv := obj.GetResourceVersion(); rv != "" {
+		w.resourceVersion = rv
+	}
+
+	return w.fn(WatchEvent{Type: eventType, Object: obj})
+}
+
+// objectKey identifies an object within a watch
+func objectKey(obj *unstructured.Unstructured) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// splitObjectKey reverses objectKey
+func splitObjectKey(key string) (string, string) {
+	namespace, name, _ := strings.Cut(key, "/")
+	return namespace, name
+}
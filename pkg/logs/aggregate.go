@@ -0,0 +1,409 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultConcurrency is the number of pods whose logs are fetched at once
+const DefaultConcurrency = 8
+
+// defaultContainerAnnotation names the container kubectl reads logs from
+// when none is given
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// PodTarget identifies a container to read logs from
+type PodTarget struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+}
+
+// PodError reports a pod whose logs could not be read
+type PodError struct {
+	Pod       string
+	Container string
+	Err       error
+}
+
+// Error implements the error interface
+func (e *PodError) Error() string {
+	if e.Container != "" {
+		return fmt.Sprintf("pod '%s' container '%s': %v", e.Pod, e.Container, e.Err)
+	}
+	return fmt.Sprintf("pod '%s': %v", e.Pod, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PodError) Unwrap() error {
+	return e.Err
+}
+
+// ResolvePod returns the containers of a pod to read logs from: the given
+// container, every init, regular and ephemeral container when
+// allContainers is set, or else the pod's default container
+func (lm *LogManager) ResolvePod(ctx context.Context, namespace, name, container string, allContainers bool) ([]PodTarget, error) {
+	if container != "" && !allContainers {
+		return []PodTarget{{Pod: name, Container: container}}, nil
+	}
+
+	clientset, err := lm.clientsetFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod '%s': %w", name, err)
+	}
+	return podTargets(pod, container, allContainers), nil
+}
+
+// ResolvePods returns the pods in a namespace matching a label selector
+// and/or the pods of a workload such as deployment/web, sorted by name,
+// with their containers chosen as by ResolvePod
+func (lm *LogManager) ResolvePods(ctx context.Context, namespace, selector, workload, container string, allContainers bool) ([]PodTarget, error) {
+	sel := labels.Everything()
+
+	if workload != "" {
+		workloadSel, err := lm.workloadSelector(ctx, namespace, workload)
+		if err != nil {
+			return nil, err
+		}
+		sel = workloadSel
+	}
+
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector '%s': %v", selector, err)
+		}
+		requirements, _ := parsed.Requirements()
+		sel = sel.Add(requirements...)
+	}
+
+	clientset, err := lm.clientsetFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+
+	targets := make([]PodTarget, 0, len(pods.Items))
+	for i := range pods.Items {
+		targets = append(targets, podTargets(&pods.Items[i], container, allContainers)...)
+	}
+
+	return targets, nil
+}
+
+// podTargets returns the containers of a pod to read logs from
+func podTargets(pod *corev1.Pod, container string, allContainers bool) []PodTarget {
+	if !allContainers {
+		if container == "" {
+			container = defaultContainer(pod)
+		}
+		return []PodTarget{{Pod: pod.Name, Container: container}}
+	}
+
+	var targets []PodTarget
+	for _, c := range pod.Spec.InitContainers {
+		targets = append(targets, PodTarget{Pod: pod.Name, Container: c.Name})
+	}
+	for _, c := range pod.Spec.Containers {
+		targets = append(targets, PodTarget{Pod: pod.Name, Container: c.Name})
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		targets = append(targets, PodTarget{Pod: pod.Name, Container: c.Name})
+	}
+	return targets
+}
+
+// workloadSelector returns the pod selector of a workload given as
+// kind/name, e.g. deployment/web, sts/db or job/migrate
+func (lm *LogManager) workloadSelector(ctx context.Context, namespace, workload string) (labels.Selector, error) {
+	kind, name, ok := strings.Cut(workload, "/")
+	if !ok || kind == "" || name == "" {
+		return nil, fmt.Errorf("invalid workload '%s': expected kind/name", workload)
+	}
+
+	clientset, err := lm.clientsetFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var selector *metav1.LabelSelector
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		d, getErr := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = d.Spec.Selector
+		}
+	case "statefulset", "statefulsets", "sts":
+		s, getErr := clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = s.Spec.Selector
+		}
+	case "daemonset", "daemonsets", "ds":
+		d, getErr := clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = d.Spec.Selector
+		}
+	case "replicaset", "replicasets", "rs":
+		r, getErr := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = r.Spec.Selector
+		}
+	case "job", "jobs":
+		j, getErr := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = j.Spec.Selector
+		}
+	default:
+		return nil, fmt.Errorf("unsupported workload kind '%s': expected deployment, statefulset, daemonset, replicaset or job", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workload '%s': %w", workload, err)
+	}
+	if selector == nil {
+		return nil, fmt.Errorf("workload '%s' has no pod selector", workload)
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector on workload '%s': %v", workload, err)
+	}
+	return sel, nil
+}
+
+// defaultContainer returns the container kubectl would read logs from
+func defaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		return name
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+// GetPodLogs retrieves logs from several pods, at most concurrency at a
+// time, and merges them in timestamp order. Pods whose logs cannot be read
+// are reported individually and do not affect the others.
+func (lm *LogManager) GetPodLogs(ctx context.Context, opts LogOptions, targets []PodTarget, concurrency int) ([]LogEntry, []*PodError) {
+	results, failures := fetchPods(ctx, opts, targets, concurrency, lm.GetLogs)
+	return mergeByTimestamp(results), failures
+}
+
+// fetchPods runs fetch for every target, at most concurrency at a time,
+// and returns the results in target order along with the failures
+func fetchPods[T any](ctx context.Context, opts LogOptions, targets []PodTarget, concurrency int, fetch func(context.Context, LogOptions) (T, error)) ([]T, []*PodError) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	results := make([]T, len(targets))
+	failures := make([]*PodError, len(targets))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				podOpts := opts
+				podOpts.Pod = targets[i].Pod
+				podOpts.Container = targets[i].Container
+
+				result, err := fetch(ctx, podOpts)
+				if err != nil {
+					failures[i] = &PodError{Pod: targets[i].Pod, Container: targets[i].Container, Err: err}
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var errs []*PodError
+	for _, failure := range failures {
+		if failure != nil {
+			errs = append(errs, failure)
+		}
+	}
+
+	return results, errs
+}
+
+// StreamPodLogs follows the logs of several pods at once, calling fn for
+// every entry as it is read. Calls to fn are serialized. Pods whose logs
+// cannot be read are reported individually; an error returned by fn stops
+// every stream and is returned.
+func (lm *LogManager) StreamPodLogs(ctx context.Context, opts LogOptions, targets []PodTarget, fn func(LogEntry) error) ([]*PodError, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		fnErr    error
+		failures []*PodError
+		wg       sync.WaitGroup
+	)
+
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target PodTarget) {
+			defer wg.Done()
+			podOpts := opts
+			podOpts.Pod = target.Pod
+			podOpts.Container = target.Container
+
+			err := lm.StreamLogs(ctx, podOpts, func(entry LogEntry) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if fnErr != nil {
+					return fnErr
+				}
+				if err := fn(entry); err != nil {
+					fnErr = err
+					cancel()
+					return err
+				}
+				return nil
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && err != fnErr {
+				failures = append(failures, &PodError{Pod: target.Pod, Container: target.Container, Err: err})
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Pod < failures[j].Pod
+	})
+	return failures, fnErr
+}
+
+// mergeBufferSize is the number of entries read ahead from each pod when
+// streams are merged
+const mergeBufferSize = 64
+
+// MergePodLogs reads the logs of several pods at once and calls fn for every
+// entry in timestamp order, holding at most a few entries per pod in memory.
+// Entries with equal timestamps keep the order of their pods. Every stream
+// stays open until it is read to the end, so the number of targets bounds
+// the open connections. Pods whose logs cannot be read are reported
+// individually; an error returned by fn stops every stream and is returned.
+func (lm *LogManager) MergePodLogs(ctx context.Context, opts LogOptions, targets []PodTarget, fn func(LogEntry) error) ([]*PodError, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streams := make([]chan LogEntry, len(targets))
+	errs := make([]error, len(targets))
+	for i, target := range targets {
+		streams[i] = make(chan LogEntry, mergeBufferSize)
+		go func(i int, target PodTarget) {
+			defer close(streams[i])
+			podOpts := opts
+			podOpts.Pod = target.Pod
+			podOpts.Container = target.Container
+
+			errs[i] = lm.StreamLogs(ctx, podOpts, func(entry LogEntry) error {
+				select {
+				case streams[i] <- entry:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}(i, target)
+	}
+
+	// heads holds the next entry of every stream, nil once it is drained
+	heads := make([]*LogEntry, len(targets))
+	next := func(i int) {
+		heads[i] = nil
+		if entry, ok := <-streams[i]; ok {
+			heads[i] = &entry
+		}
+	}
+	for i := range streams {
+		next(i)
+	}
+
+	for {
+		best := -1
+		for i, head := range heads {
+			if head != nil && (best < 0 || head.Timestamp.Before(heads[best].Timestamp)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		if err := fn(*heads[best]); err != nil {
+			return nil, err
+		}
+		next(best)
+	}
+
+	// Every stream is closed, so its error has been recorded
+	var failures []*PodError
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, &PodError{Pod: targets[i].Pod, Container: targets[i].Container, Err: err})
+		}
+	}
+	return failures, nil
+}
+
+// mergeByTimestamp merges per-pod entries, each already in timestamp order,
+// into a single stream ordered by timestamp. Entries with equal timestamps
+// keep the order of their pods.
+func mergeByTimestamp(streams [][]LogEntry) []LogEntry {
+	total := 0
+	for _, stream := range streams {
+		total += len(stream)
+	}
+
+	merged := make([]LogEntry, 0, total)
+	next := make([]int, len(streams))
+	for len(merged) < total {
+		best := -1
+		for i, stream := range streams {
+			if next[i] >= len(stream) {
+				continue
+			}
+			if best < 0 || stream[next[i]].Timestamp.Before(streams[best][next[best]].Timestamp) {
+				best = i
+			}
+		}
+		merged = append(merged, streams[best][next[best]])
+		next[best]++
+	}
+
+	return merged
+}
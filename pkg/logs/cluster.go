@@ -0,0 +1,247 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Template mining defaults
+const (
+	// DefaultMaxTemplates is the number of templates returned by default
+	DefaultMaxTemplates = 100
+	// templateSimilarity is the fraction of tokens a line must share with
+	// a template to join it
+	templateSimilarity = 0.5
+	// templateExamples is the number of example lines kept per template
+	templateExamples = 3
+	// maxExampleLength truncates long example lines
+	maxExampleLength = 500
+	// wildcard replaces the tokens that vary within a template
+	wildcard = "<*>"
+)
+
+// variableMasks replace tokens that are almost always variable before lines
+// are compared, most specific first
+var variableMasks = []struct {
+	pattern *regexp.Regexp
+	mask    string
+	// minLength, if set, leaves shorter matches unmasked
+	minLength int
+}{
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<UUID>", 0},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`), "<TIME>", 0},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), "<IP>", 0},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<HEX>", 0},
+	{regexp.MustCompile(`\b[0-9a-fA-F]*\d[0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*\b|\b[0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*\d[0-9a-fA-F]*\b`), "<HEX>", 6},
+	{regexp.MustCompile(`[-+]?\b\d+(?:\.\d+)?(?:ms|us|µs|ns|s|m|h|%|[KMGT]i?B|B)?\b`), "<NUM>", 0},
+}
+
+// LogTemplate is a group of log lines that differ only in variable tokens
+type LogTemplate struct {
+	ID int `json:"id"`
+	// Template is the shared text, with variable tokens replaced by <*> or
+	// a typed mask such as <NUM>, <IP> or <UUID>
+	Template  string    `json:"template"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Examples  []string  `json:"examples"`
+}
+
+// LogSummary is the result of template mining
+type LogSummary struct {
+	// Entries is the number of entries mined
+	Entries int `json:"entries"`
+	// TemplateCount is the number of templates found
+	TemplateCount int `json:"template_count"`
+	// Templates are the most frequent templates, most frequent first
+	Templates []LogTemplate `json:"templates"`
+	// Truncated is set when only some templates are returned
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// TemplateMiner groups log entries into templates with a variant of the
+// Drain algorithm: lines are tokenized, obviously variable tokens are
+// masked, and each line joins the most similar template with the same token
+// count and first token, turning the tokens that differ into wildcards.
+// It is safe for concurrent use.
+type TemplateMiner struct {
+	mu      sync.Mutex
+	entries int
+	// groups holds the templates by token count and first token, the
+	// fixed-depth prefix tree of Drain
+	groups    map[string][]*LogTemplate
+	templates []*LogTemplate
+	// tokens holds the tokenized template of each template, by ID
+	tokens map[int][]string
+}
+
+// NewTemplateMiner creates an empty miner
+func NewTemplateMiner() *TemplateMiner {
+	return &TemplateMiner{
+		groups: make(map[string][]*LogTemplate),
+		tokens: make(map[int][]string),
+	}
+}
+
+// Add mines an entry. Multi-line entries are grouped by their first line.
+func (m *TemplateMiner) Add(entry LogEntry) {
+	line, _, _ := strings.Cut(entry.Message, "\n")
+	tokens := templateTokens(line)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries++
+
+	key := groupKey(tokens)
+	var best *LogTemplate
+	bestScore := -1.0
+	for _, candidate := range m.groups[key] {
+		if score := similarity(m.tokens[candidate.ID], tokens); score >= templateSimilarity && score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best == nil {
+		best = &LogTemplate{
+			ID:        len(m.templates) + 1,
+			FirstSeen: entry.Timestamp,
+			LastSeen:  entry.Timestamp,
+		}
+		m.tokens[best.ID] = tokens
+		m.groups[key] = append(m.groups[key], best)
+		m.templates = append(m.templates, best)
+	} else {
+		template := m.tokens[best.ID]
+		for i := range template {
+			if template[i] != tokens[i] {
+				template[i] = wildcard
+			}
+		}
+	}
+
+	best.Count++
+	if entry.Timestamp.Before(best.FirstSeen) {
+		best.FirstSeen = entry.Timestamp
+	}
+	if entry.Timestamp.After(best.LastSeen) {
+		best.LastSeen = entry.Timestamp
+	}
+	if len(best.Examples) < templateExamples {
+		if example := truncateExample(line); !containsString(best.Examples, example) {
+			best.Examples = append(best.Examples, example)
+		}
+	}
+}
+
+// truncateExample shortens a line to at most maxExampleLength bytes, cutting
+// at the start of a rune so multi-byte characters are not split
+func truncateExample(line string) string {
+	if len(line) <= maxExampleLength {
+		return line
+	}
+	end := maxExampleLength
+	for end > 0 && !utf8.RuneStart(line[end]) {
+		end--
+	}
+	return line[:end] + "..."
+}
+
+// Summary returns up to max templates, most frequent first; max <= 0
+// returns every template
+func (m *TemplateMiner) Summary(max int) *LogSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	templates := make([]LogTemplate, 0, len(m.templates))
+	for _, t := range m.templates {
+		template := *t
+		template.Template = strings.Join(m.tokens[t.ID], " ")
+		template.Examples = append([]string(nil), t.Examples...)
+		templates = append(templates, template)
+	}
+	sort.SliceStable(templates, func(i, j int) bool {
+		return templates[i].Count > templates[j].Count
+	})
+
+	summary := &LogSummary{Entries: m.entries, TemplateCount: len(templates), Templates: templates}
+	if max > 0 && len(templates) > max {
+		summary.Templates = templates[:max]
+		summary.Truncated = true
+	}
+	return summary
+}
+
+// templateTokens masks the variable parts of a line and splits it into
+// tokens
+func templateTokens(line string) []string {
+	for _, mask := range variableMasks {
+		line = mask.pattern.ReplaceAllStringFunc(line, func(match string) string {
+			if len(match) < mask.minLength {
+				return match
+			}
+			return mask.mask
+		})
+	}
+	return strings.Fields(line)
+}
+
+// groupKey places a line in the prefix tree by its token count and first
+// token; a first token holding digits is likely variable and is keyed as a
+// wildcard
+func groupKey(tokens []string) string {
+	if len(tokens) == 0 {
+		return "0"
+	}
+	first := tokens[0]
+	if strings.ContainsAny(first, "0123456789") {
+		first = wildcard
+	}
+	return fmt.Sprintf("%d %s", len(tokens), first)
+}
+
+// similarity returns the fraction of positions where a line matches a
+// template of the same length; wildcards do not count as matches, so
+// templates that are already general do not absorb unrelated lines
+func similarity(template, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	same := 0
+	for i := range template {
+		if template[i] == tokens[i] && template[i] != wildcard {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPodTemplates mines the filtered logs of several pods into templates,
+// reading at most concurrency pods at a time. Pods whose logs cannot be
+// read are reported individually.
+func (lm *LogManager) GetPodTemplates(ctx context.Context, opts LogOptions, targets []PodTarget, concurrency, maxTemplates int) (*LogSummary, []*PodError) {
+	miner := NewTemplateMiner()
+	_, failures := fetchPods(ctx, opts, targets, concurrency, func(ctx context.Context, opts LogOptions) (struct{}, error) {
+		return struct{}{}, lm.StreamLogs(ctx, opts, func(entry LogEntry) error {
+			miner.Add(entry)
+			return nil
+		})
+	})
+	return miner.Summary(maxTemplates), failures
+}
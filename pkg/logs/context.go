@@ -0,0 +1,122 @@
+package logs
+
+import (
+	"context"
+	"sort"
+)
+
+// Hunk is a run of consecutive entries from one container holding one or
+// more matches and the context lines around them, like a block of grep -C
+// output
+type Hunk struct {
+	Pod       string     `json:"pod"`
+	Container string     `json:"container,omitempty"`
+	Entries   []LogEntry `json:"entries"`
+	// Matches are the offsets in Entries of the entries that matched
+	Matches []int `json:"matches"`
+}
+
+// GetHunks searches the logs of a pod and returns every match with up to
+// before entries preceding it and after entries following it. Overlapping
+// or adjacent windows are merged into a single hunk.
+func (lm *LogManager) GetHunks(ctx context.Context, opts LogOptions, before, after int) ([]Hunk, error) {
+	hunks := []Hunk{}
+	builder := &hunkBuilder{
+		pod:       opts.Pod,
+		container: opts.Container,
+		before:    before,
+		after:     after,
+		emit: func(hunk Hunk) {
+			hunks = append(hunks, hunk)
+		},
+	}
+
+	if err := lm.streamEntries(ctx, opts, func(entry LogEntry, matched bool) error {
+		builder.add(entry, matched)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	builder.finish()
+
+	return hunks, nil
+}
+
+// GetPodHunks searches the logs of several pods like GetHunks, at most
+// concurrency at a time, and orders the hunks by the time of their first
+// entry
+func (lm *LogManager) GetPodHunks(ctx context.Context, opts LogOptions, targets []PodTarget, concurrency, before, after int) ([]Hunk, []*PodError) {
+	results, failures := fetchPods(ctx, opts, targets, concurrency, func(ctx context.Context, opts LogOptions) ([]Hunk, error) {
+		return lm.GetHunks(ctx, opts, before, after)
+	})
+
+	hunks := []Hunk{}
+	for _, result := range results {
+		hunks = append(hunks, result...)
+	}
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].Entries[0].Timestamp.Before(hunks[j].Entries[0].Timestamp)
+	})
+
+	return hunks, failures
+}
+
+// hunkBuilder groups a stream of entries into hunks
+type hunkBuilder struct {
+	pod       string
+	container string
+	before    int
+	after     int
+	emit      func(Hunk)
+
+	// current is the open hunk, if any
+	current *Hunk
+	// pending holds the entries after the current hunk's after window, or
+	// the last before entries when no hunk is open
+	pending []LogEntry
+	// afterLeft counts the entries still owed to the after window
+	afterLeft int
+}
+
+// add adds the next entry of the stream
+func (b *hunkBuilder) add(entry LogEntry, matched bool) {
+	switch {
+	case matched:
+		if b.current == nil {
+			b.current = &Hunk{Pod: b.pod, Container: b.container}
+		}
+		// Entries between the previous window and this match are at most
+		// before entries apart, so the windows touch and are merged
+		b.current.Entries = append(b.current.Entries, b.pending...)
+		b.pending = b.pending[:0]
+
+		b.current.Matches = append(b.current.Matches, len(b.current.Entries))
+		b.current.Entries = append(b.current.Entries, entry)
+		b.afterLeft = b.after
+
+	case b.current != nil && b.afterLeft > 0:
+		b.current.Entries = append(b.current.Entries, entry)
+		b.afterLeft--
+
+	default:
+		b.pending = append(b.pending, entry)
+		if len(b.pending) > b.before {
+			// The gap is too wide for the next match's window to reach
+			// the open hunk
+			if b.current != nil {
+				b.emit(*b.current)
+				b.current = nil
+			}
+			b.pending = append(b.pending[:0], b.pending[1:]...)
+		}
+	}
+}
+
+// finish emits the open hunk, if any
+func (b *hunkBuilder) finish() {
+	if b.current != nil {
+		b.emit(*b.current)
+		b.current = nil
+	}
+	b.pending = nil
+}
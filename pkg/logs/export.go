@@ -0,0 +1,285 @@
+package logs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EntryWriter writes log entries in an export format one at a time, so an
+// export never has to be held in memory
+type EntryWriter interface {
+	// Write writes an entry
+	Write(entry LogEntry) error
+	// Close writes what the format needs after the last entry and flushes
+	// buffered output. It does not close the underlying writer.
+	Close() error
+}
+
+// ExportFormat describes a log export format
+type ExportFormat struct {
+	Name string
+	// ContentType is the media type of the exported document
+	ContentType string
+	// Extension is the file name extension of the exported document
+	Extension string
+	// NewWriter returns an EntryWriter writing to w
+	NewWriter func(w io.Writer) EntryWriter
+}
+
+// Registered export formats
+var (
+	exportFormatsMu sync.RWMutex
+	exportFormats   = make(map[string]*ExportFormat)
+)
+
+// exportAliases maps alternative names to export formats
+var exportAliases = map[string]string{
+	"text": "plaintext",
+}
+
+func init() {
+	RegisterExportFormat(&ExportFormat{
+		Name:        "json",
+		ContentType: "application/json",
+		Extension:   "json",
+		NewWriter:   func(w io.Writer) EntryWriter { return &jsonArrayWriter{w: w} },
+	})
+	RegisterExportFormat(&ExportFormat{
+		Name:        "ndjson",
+		ContentType: "application/x-ndjson",
+		Extension:   "ndjson",
+		NewWriter:   func(w io.Writer) EntryWriter { return &ndjsonEntryWriter{encoder: json.NewEncoder(w)} },
+	})
+	RegisterExportFormat(&ExportFormat{
+		Name:        "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		NewWriter:   func(w io.Writer) EntryWriter { return &csvEntryWriter{writer: csv.NewWriter(w)} },
+	})
+	RegisterExportFormat(&ExportFormat{
+		Name:        "plaintext",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "log",
+		NewWriter:   func(w io.Writer) EntryWriter { return &plaintextWriter{w: w} },
+	})
+	RegisterExportFormat(&ExportFormat{
+		Name:        "logfmt",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "logfmt",
+		NewWriter:   func(w io.Writer) EntryWriter { return &logfmtWriter{w: w} },
+	})
+	RegisterExportFormat(syslogFormat(DefaultSyslogEnterpriseID))
+	RegisterExportFormat(&ExportFormat{
+		Name:        "otlp",
+		ContentType: "application/json",
+		Extension:   "otlp.json",
+		NewWriter:   func(w io.Writer) EntryWriter { return newBatchWriter(w, otlpDocument{}) },
+	})
+	RegisterExportFormat(&ExportFormat{
+		Name:        "loki",
+		ContentType: "application/json",
+		Extension:   "loki.json",
+		NewWriter:   func(w io.Writer) EntryWriter { return newBatchWriter(w, lokiDocument{exportedAt: time.Now()}) },
+	})
+}
+
+// RegisterExportFormat makes an export format available by name, replacing
+// any format registered under the same name
+func RegisterExportFormat(format *ExportFormat) {
+	exportFormatsMu.Lock()
+	defer exportFormatsMu.Unlock()
+	exportFormats[format.Name] = format
+}
+
+// GetExportFormat returns the export format registered under name
+func GetExportFormat(name string) (*ExportFormat, error) {
+	key := strings.ToLower(name)
+	if alias, ok := exportAliases[key]; ok {
+		key = alias
+	}
+
+	exportFormatsMu.RLock()
+	defer exportFormatsMu.RUnlock()
+	format, ok := exportFormats[key]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format: %s", name)
+	}
+	return format, nil
+}
+
+// ExportFormatNames returns the names of the registered export formats,
+// sorted
+func ExportFormatNames() []string {
+	exportFormatsMu.RLock()
+	defer exportFormatsMu.RUnlock()
+
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportLogs exports logs in the specified format
+func (lm *LogManager) ExportLogs(entries []LogEntry, format string, writer io.Writer) error {
+	exportFormat, err := GetExportFormat(format)
+	if err != nil {
+		return err
+	}
+
+	entryWriter := exportFormat.NewWriter(writer)
+	for _, entry := range entries {
+		if err := entryWriter.Write(entry); err != nil {
+			return err
+		}
+	}
+	return entryWriter.Close()
+}
+
+// jsonArrayWriter writes entries as the elements of a JSON array
+type jsonArrayWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// Write implements EntryWriter
+func (j *jsonArrayWriter) Write(entry LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding JSON record: %v", err)
+	}
+
+	separator := ","
+	if !j.started {
+		separator = "["
+		j.started = true
+	}
+	if _, err := io.WriteString(j.w, separator); err != nil {
+		return fmt.Errorf("error writing JSON record: %v", err)
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return fmt.Errorf("error writing JSON record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (j *jsonArrayWriter) Close() error {
+	end := "]\n"
+	if !j.started {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(j.w, end); err != nil {
+		return fmt.Errorf("error writing JSON record: %v", err)
+	}
+	return nil
+}
+
+// ndjsonEntryWriter writes one JSON entry per line
+type ndjsonEntryWriter struct {
+	encoder *json.Encoder
+}
+
+// Write implements EntryWriter
+func (n *ndjsonEntryWriter) Write(entry LogEntry) error {
+	if err := n.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("error writing NDJSON record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (n *ndjsonEntryWriter) Close() error {
+	return nil
+}
+
+// csvEntryWriter writes entries as CSV records under a header row
+type csvEntryWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// writeHeader writes the header row once
+func (c *csvEntryWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	if err := c.writer.Write([]string{"Timestamp", "Pod", "Container", "Namespace", "Level", "Message", "Fields"}); err != nil {
+		return fmt.Errorf("error writing CSV header: %v", err)
+	}
+	return nil
+}
+
+// Write implements EntryWriter
+func (c *csvEntryWriter) Write(entry LogEntry) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	// Fields are written as a JSON object
+	var fields []byte
+	if len(entry.Fields) > 0 {
+		var err error
+		if fields, err = json.Marshal(entry.Fields); err != nil {
+			return fmt.Errorf("error encoding CSV fields: %v", err)
+		}
+	}
+
+	if err := c.writer.Write([]string{
+		entry.Timestamp.Format(time.RFC3339Nano),
+		entry.Pod,
+		entry.Container,
+		entry.Namespace,
+		entry.LogLevel,
+		entry.Message,
+		string(fields),
+	}); err != nil {
+		return fmt.Errorf("error writing CSV record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (c *csvEntryWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV record: %v", err)
+	}
+	return nil
+}
+
+// plaintextWriter writes one human-readable line per entry
+type plaintextWriter struct {
+	w io.Writer
+}
+
+// Write implements EntryWriter
+func (p *plaintextWriter) Write(entry LogEntry) error {
+	line := fmt.Sprintf("[%s] [%s] [%s/%s] [%s] %s\n",
+		entry.Timestamp.Format(time.RFC3339Nano),
+		entry.Namespace,
+		entry.Pod,
+		entry.Container,
+		entry.LogLevel,
+		entry.Message+formatFields(entry.Fields))
+	if _, err := io.WriteString(p.w, line); err != nil {
+		return fmt.Errorf("error writing plaintext record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (p *plaintextWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,477 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportBatchSize bounds the entries held by formats that group entries
+// into streams; each batch is grouped and written before the next is read
+const exportBatchSize = 1000
+
+// otlpScopeName is the instrumentation scope of exported OTLP records
+const otlpScopeName = "k8s-mcp-server"
+
+// DefaultSyslogEnterpriseID qualifies the structured data IDs of syslog
+// records unless another is configured with NewSyslogFormat. It is the
+// example enterprise number reserved for documentation by RFC 5612;
+// organizations should use their own IANA private enterprise number.
+const DefaultSyslogEnterpriseID = "32473"
+
+// syslogEnterpriseIDPattern matches a private enterprise number, optionally
+// followed by dotted sub-identifiers
+var syslogEnterpriseIDPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// otlpSeverities maps levels to OpenTelemetry severity numbers
+var otlpSeverities = map[string]int{"DEBUG": 5, "INFO": 9, "WARN": 13, "ERROR": 17, "FATAL": 21}
+
+// syslogSeverities maps levels to syslog severities; entries without a
+// level are informational
+var syslogSeverities = map[string]int{"DEBUG": 7, "INFO": 6, "WARN": 4, "ERROR": 3, "FATAL": 2}
+
+// lineEscaper keeps multi-line messages on a single line
+var lineEscaper = strings.NewReplacer("\r", `\r`, "\n", `\n`)
+
+// logfmtWriter writes one logfmt line per entry
+type logfmtWriter struct {
+	w io.Writer
+}
+
+// Write implements EntryWriter
+func (l *logfmtWriter) Write(entry LogEntry) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s", entry.Timestamp.Format(time.RFC3339Nano))
+	if entry.LogLevel != "" {
+		fmt.Fprintf(&b, " level=%s", strings.ToLower(entry.LogLevel))
+	}
+	fmt.Fprintf(&b, " namespace=%s pod=%s", logfmtValue(entry.Namespace), logfmtValue(entry.Pod))
+	if entry.Container != "" {
+		fmt.Fprintf(&b, " container=%s", logfmtValue(entry.Container))
+	}
+	fmt.Fprintf(&b, " msg=%s%s\n", logfmtValue(entry.Message), formatFields(entry.Fields))
+
+	if _, err := io.WriteString(l.w, b.String()); err != nil {
+		return fmt.Errorf("error writing logfmt record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (l *logfmtWriter) Close() error {
+	return nil
+}
+
+// syslogWriter writes one RFC 5424 syslog line per entry. The pod is the
+// hostname and the container the app name; the namespace, level and
+// structured fields are written as structured data whose IDs are qualified
+// by enterpriseID.
+type syslogWriter struct {
+	w            io.Writer
+	enterpriseID string
+}
+
+// NewSyslogFormat returns the syslog export format with structured data IDs
+// qualified by the given private enterprise number, e.g. k8s@32473. Register
+// it with RegisterExportFormat to replace the default.
+func NewSyslogFormat(enterpriseID string) (*ExportFormat, error) {
+	if !syslogEnterpriseIDPattern.MatchString(enterpriseID) {
+		return nil, fmt.Errorf("invalid syslog enterprise ID '%s' (expected a private enterprise number such as 32473)", enterpriseID)
+	}
+	return syslogFormat(enterpriseID), nil
+}
+
+// syslogFormat returns the syslog export format for a valid enterprise ID
+func syslogFormat(enterpriseID string) *ExportFormat {
+	return &ExportFormat{
+		Name:        "syslog",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "syslog",
+		NewWriter:   func(w io.Writer) EntryWriter { return &syslogWriter{w: w, enterpriseID: enterpriseID} },
+	}
+}
+
+// Write implements EntryWriter
+func (s *syslogWriter) Write(entry LogEntry) error {
+	severity, ok := syslogSeverities[entry.LogLevel]
+	if !ok {
+		severity = syslogSeverities["INFO"]
+	}
+	// Facility 1 is user-level messages
+	priority := 1*8 + severity
+
+	timestamp := "-"
+	if !entry.Timestamp.IsZero() {
+		timestamp = entry.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	var sd strings.Builder
+	fmt.Fprintf(&sd, "[k8s@%s namespace=\"%s\" pod=\"%s\"", s.enterpriseID, syslogParamValue(entry.Namespace), syslogParamValue(entry.Pod))
+	if entry.Container != "" {
+		fmt.Fprintf(&sd, " container=\"%s\"", syslogParamValue(entry.Container))
+	}
+	if entry.LogLevel != "" {
+		fmt.Fprintf(&sd, " level=\"%s\"", syslogParamValue(entry.LogLevel))
+	}
+	sd.WriteString("]")
+	if len(entry.Fields) > 0 {
+		fmt.Fprintf(&sd, "[fields@%s", s.enterpriseID)
+		for _, key := range sortedKeys(entry.Fields) {
+			value, ok := entry.Fields[key].(string)
+			if !ok {
+				encoded, _ := json.Marshal(entry.Fields[key])
+				value = string(encoded)
+			}
+			fmt.Fprintf(&sd, " %s=\"%s\"", syslogName(key, 32), syslogParamValue(value))
+		}
+		sd.WriteString("]")
+	}
+
+	line := fmt.Sprintf("<%d>1 %s %s %s - - %s %s\n",
+		priority,
+		timestamp,
+		syslogName(entry.Pod, 255),
+		syslogName(entry.Container, 48),
+		sd.String(),
+		lineEscaper.Replace(entry.Message))
+	if _, err := io.WriteString(s.w, line); err != nil {
+		return fmt.Errorf("error writing syslog record: %v", err)
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (s *syslogWriter) Close() error {
+	return nil
+}
+
+// syslogName renders a syslog header field or parameter name: printable
+// ASCII without spaces, equal signs, brackets or quotes, at most max bytes,
+// or - when empty
+func syslogName(value string, max int) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(name) > max {
+		name = name[:max]
+	}
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// syslogParamValue escapes a structured data parameter value
+func syslogParamValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+	return lineEscaper.Replace(value)
+}
+
+// sortedKeys returns the keys of fields, sorted
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// groupedDocument is a JSON document holding entries grouped into streams,
+// such as OTLP resource logs or Loki streams
+type groupedDocument interface {
+	// prefix and suffix surround the comma separated groups
+	prefix() string
+	suffix() string
+	// groupKey identifies the group an entry belongs to
+	groupKey(entry LogEntry) string
+	// encodeGroup renders a group of entries sharing a key
+	encodeGroup(entries []LogEntry) ([]byte, error)
+}
+
+// batchWriter writes a groupedDocument, grouping up to exportBatchSize
+// entries at a time. A stream may therefore appear once per batch.
+type batchWriter struct {
+	w       io.Writer
+	doc     groupedDocument
+	batch   []LogEntry
+	started bool
+}
+
+// newBatchWriter creates a batchWriter for a document
+func newBatchWriter(w io.Writer, doc groupedDocument) *batchWriter {
+	return &batchWriter{w: w, doc: doc}
+}
+
+// Write implements EntryWriter
+func (b *batchWriter) Write(entry LogEntry) error {
+	b.batch = append(b.batch, entry)
+	if len(b.batch) >= exportBatchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered entries as groups in order of first appearance
+func (b *batchWriter) flush() error {
+	var keys []string
+	groups := make(map[string][]LogEntry)
+	for _, entry := range b.batch {
+		key := b.doc.groupKey(entry)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	b.batch = b.batch[:0]
+
+	for _, key := range keys {
+		data, err := b.doc.encodeGroup(groups[key])
+		if err != nil {
+			return fmt.Errorf("error encoding export record: %v", err)
+		}
+
+		separator := ","
+		if !b.started {
+			separator = b.doc.prefix()
+			b.started = true
+		}
+		if _, err := io.WriteString(b.w, separator); err != nil {
+			return fmt.Errorf("error writing export record: %v", err)
+		}
+		if _, err := b.w.Write(data); err != nil {
+			return fmt.Errorf("error writing export record: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close implements EntryWriter
+func (b *batchWriter) Close() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	end := b.doc.suffix()
+	if !b.started {
+		end = b.doc.prefix() + end
+	}
+	if _, err := io.WriteString(b.w, end); err != nil {
+		return fmt.Errorf("error writing export record: %v", err)
+	}
+	return nil
+}
+
+// otlpDocument renders entries as an OTLP/JSON ExportLogsServiceRequest, as
+// accepted by the /v1/logs endpoint of OpenTelemetry collectors. Each
+// container becomes a resource with Kubernetes semantic convention
+// attributes.
+type otlpDocument struct{}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue holds exactly one of its fields, or none for an empty value.
+// 64-bit integers are strings, as the protobuf JSON mapping requires.
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    *string          `json:"intValue,omitempty"`
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+func (otlpDocument) prefix() string { return `{"resourceLogs":[` }
+func (otlpDocument) suffix() string { return "]}\n" }
+
+func (otlpDocument) groupKey(entry LogEntry) string {
+	return entry.Namespace + "/" + entry.Pod + "/" + entry.Container
+}
+
+func (otlpDocument) encodeGroup(entries []LogEntry) ([]byte, error) {
+	first := entries[0]
+	resource := otlpResource{Attributes: []otlpKeyValue{
+		{Key: "k8s.namespace.name", Value: otlpString(first.Namespace)},
+		{Key: "k8s.pod.name", Value: otlpString(first.Pod)},
+	}}
+	if first.Container != "" {
+		resource.Attributes = append(resource.Attributes, otlpKeyValue{Key: "k8s.container.name", Value: otlpString(first.Container)})
+	}
+
+	records := make([]otlpLogRecord, 0, len(entries))
+	for _, entry := range entries {
+		// The time of the event is the one written by the application when
+		// known, and the observed time the one the kubelet received it at
+		eventTime := entry.Timestamp
+		if entry.AppTimestamp != nil {
+			eventTime = *entry.AppTimestamp
+		}
+
+		record := otlpLogRecord{
+			TimeUnixNano:         otlpTime(eventTime),
+			ObservedTimeUnixNano: otlpTime(entry.Timestamp),
+			SeverityNumber:       otlpSeverities[entry.LogLevel],
+			SeverityText:         entry.LogLevel,
+			Body:                 otlpString(entry.Message),
+		}
+		for _, key := range sortedKeys(entry.Fields) {
+			record.Attributes = append(record.Attributes, otlpKeyValue{Key: key, Value: otlpValue(entry.Fields[key])})
+		}
+		records = append(records, record)
+	}
+
+	return json.Marshal(otlpResourceLogs{
+		Resource: resource,
+		ScopeLogs: []otlpScopeLogs{{
+			Scope:      otlpScope{Name: otlpScopeName},
+			LogRecords: records,
+		}},
+	})
+}
+
+// otlpTime formats a time as nanoseconds since the Unix epoch. Unknown
+// times are 0, which OTLP reads as unset, rather than the negative count of
+// the zero time.
+func otlpTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpString returns a string AnyValue
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+// otlpValue converts a decoded JSON value to an AnyValue
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case string:
+		return otlpString(v)
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			i := strconv.FormatInt(int64(v), 10)
+			return otlpAnyValue{IntValue: &i}
+		}
+		return otlpAnyValue{DoubleValue: &v}
+	case []interface{}:
+		array := &otlpArrayValue{Values: make([]otlpAnyValue, 0, len(v))}
+		for _, item := range v {
+			array.Values = append(array.Values, otlpValue(item))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	case map[string]interface{}:
+		kvlist := &otlpKvlistValue{Values: make([]otlpKeyValue, 0, len(v))}
+		for _, key := range sortedKeys(v) {
+			kvlist.Values = append(kvlist.Values, otlpKeyValue{Key: key, Value: otlpValue(v[key])})
+		}
+		return otlpAnyValue{KvlistValue: kvlist}
+	case nil:
+		return otlpAnyValue{}
+	default:
+		return otlpString(fmt.Sprint(v))
+	}
+}
+
+// lokiDocument renders entries as a Loki push API request, as accepted by
+// /loki/api/v1/push. Entries are grouped into streams labelled with their
+// namespace, pod, container and level; structured fields are appended to
+// the line in logfmt. Loki rejects entries without a recent timestamp, so
+// entries without one are stamped with exportedAt.
+type lokiDocument struct {
+	exportedAt time.Time
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (lokiDocument) prefix() string { return `{"streams":[` }
+func (lokiDocument) suffix() string { return "]}\n" }
+
+func (lokiDocument) groupKey(entry LogEntry) string {
+	return entry.Namespace + "/" + entry.Pod + "/" + entry.Container + "/" + entry.LogLevel
+}
+
+func (d lokiDocument) encodeGroup(entries []LogEntry) ([]byte, error) {
+	first := entries[0]
+	stream := lokiStream{
+		Stream: map[string]string{"namespace": first.Namespace, "pod": first.Pod},
+		Values: make([][2]string, 0, len(entries)),
+	}
+	if first.Container != "" {
+		stream.Stream["container"] = first.Container
+	}
+	if first.LogLevel != "" {
+		stream.Stream["level"] = strings.ToLower(first.LogLevel)
+	}
+
+	for _, entry := range entries {
+		stream.Values = append(stream.Values, [2]string{
+			d.lokiTime(entry.Timestamp),
+			entry.Message + formatFields(entry.Fields),
+		})
+	}
+
+	return json.Marshal(stream)
+}
+
+// lokiTime formats a time as nanoseconds since the Unix epoch, using the
+// export time for unknown times
+func (d lokiDocument) lokiTime(t time.Time) string {
+	if t.IsZero() {
+		t = d.exportedAt
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -3,14 +3,16 @@ package logs
 import (
 	"bufio"
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -19,16 +21,27 @@ import (
 // LogManager handles log operations
 type LogManager struct {
 	clientset *kubernetes.Clientset
+	redactor  *redact.Redactor
+	// clientsetFunc, if set, returns the clientset to serve each request
+	// with instead of clientset
+	clientsetFunc func(context.Context) (kubernetes.Interface, error)
 }
 
 // LogEntry represents a structured log entry
 type LogEntry struct {
+	// Timestamp is when the kubelet received the line
 	Timestamp time.Time `json:"timestamp"`
-	Message   string    `json:"message"`
-	Pod       string    `json:"pod"`
-	Container string    `json:"container"`
-	Namespace string    `json:"namespace"`
-	LogLevel  string    `json:"level,omitempty"`
+	// AppTimestamp is the timestamp written by the application in the
+	// message, if any
+	AppTimestamp *time.Time `json:"app_timestamp,omitempty"`
+	Message      string     `json:"message"`
+	Pod          string     `json:"pod"`
+	Container    string     `json:"container"`
+	Namespace    string     `json:"namespace"`
+	LogLevel     string     `json:"level,omitempty"`
+	// Fields holds the structured attributes of JSON, logfmt and other
+	// recognized log formats
+	Fields map[string]interface{} `json:"fields,omitempty"`
 }
 
 // LogOptions represents options for retrieving logs
@@ -41,6 +54,21 @@ type LogOptions struct {
 	Tail         *int64
 	Pattern      string
 	LogLevel     string
+	// Follow keeps the stream open for new entries, like kubectl logs -f
+	Follow bool
+	// Previous reads the logs of the previous, terminated instance of the
+	// container, like kubectl logs -p
+	Previous bool
+	// Parser names the registered Parser used to structure each line;
+	// defaults to DefaultParser
+	Parser string
+	// Query, if set, filters entries on their parsed fields
+	Query *Query
+	// Multiline selects how lines are grouped into events: MultilineAuto
+	// (the default) or MultilineNone. MultilineStart, if set, is a regular
+	// expression matching the first line of every event instead.
+	Multiline      string
+	MultilineStart string
 }
 
 // NewLogManager creates a new LogManager
@@ -50,14 +78,85 @@ func NewLogManager(clientset *kubernetes.Clientset) *LogManager {
 	}
 }
 
+// SetClientsetFunc makes the manager serve each request with the
+// clientset fn returns for its context, e.g. one impersonating the caller
+func (lm *LogManager) SetClientsetFunc(fn func(context.Context) (kubernetes.Interface, error)) {
+	lm.clientsetFunc = fn
+}
+
+// clientsetFor returns the clientset to serve a request with
+func (lm *LogManager) clientsetFor(ctx context.Context) (kubernetes.Interface, error) {
+	if lm.clientsetFunc != nil {
+		return lm.clientsetFunc(ctx)
+	}
+	return lm.clientset, nil
+}
+
+// SetRedactor sets the redactor applied to every entry read, before
+// entries are filtered so that searches cannot probe masked values
+func (lm *LogManager) SetRedactor(r *redact.Redactor) {
+	lm.redactor = r
+}
+
 // GetLogs retrieves logs from a pod
-func (lm *LogManager) GetLogs(opts LogOptions) ([]LogEntry, error) {
+func (lm *LogManager) GetLogs(ctx context.Context, opts LogOptions) ([]LogEntry, error) {
+	var logEntries []LogEntry
+	err := lm.StreamLogs(ctx, opts, func(entry LogEntry) error {
+		logEntries = append(logEntries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return logEntries, nil
+}
+
+// StreamLogs retrieves logs from a pod and calls fn for every entry that
+// passes the pattern, level and query filters, as it is read. Lines are
+// grouped into multi-line events such as stack traces first. With Follow
+// set, the stream stays open for new entries until ctx is done, which ends
+// the stream cleanly. An error returned by fn stops the stream and is
+// returned.
+func (lm *LogManager) StreamLogs(ctx context.Context, opts LogOptions, fn func(LogEntry) error) error {
+	return lm.streamEntries(ctx, opts, func(entry LogEntry, matched bool) error {
+		if !matched {
+			return nil
+		}
+		return fn(entry)
+	})
+}
+
+// streamEntries reads the logs of a pod like StreamLogs, but calls fn for
+// every entry and reports whether it passes the filters
+func (lm *LogManager) streamEntries(ctx context.Context, opts LogOptions, fn func(entry LogEntry, matched bool) error) error {
+	parser, err := GetParser(opts.Parser)
+	if err != nil {
+		return err
+	}
+
+	grouper, err := newEventGrouper(opts.Multiline, opts.MultilineStart)
+	if err != nil {
+		return err
+	}
+
+	// Compile regex pattern if provided
+	var re *regexp.Regexp
+	if opts.Pattern != "" {
+		re, err = regexp.Compile(opts.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid regex pattern: %v", err)
+		}
+	}
+
 	podLogOpts := corev1.PodLogOptions{
 		Container:    opts.Container,
 		SinceTime:    nil,
 		SinceSeconds: opts.SinceSeconds,
 		TailLines:    opts.Tail,
-		Follow:       false,
+		Follow:       opts.Follow,
+		Previous:     opts.Previous,
+		Timestamps:   true,
 	}
 
 	if opts.SinceTime != nil {
@@ -65,150 +164,200 @@ func (lm *LogManager) GetLogs(opts LogOptions) ([]LogEntry, error) {
 		podLogOpts.SinceTime = &sinceTime
 	}
 
-	req := lm.clientset.CoreV1().Pods(opts.Namespace).GetLogs(opts.Pod, &podLogOpts)
-	podLogs, err := req.Stream(context.TODO())
+	clientset, err := lm.clientsetFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := clientset.CoreV1().Pods(opts.Namespace).GetLogs(opts.Pod, &podLogOpts)
+	podLogs, err := req.Stream(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error opening log stream: %v", err)
+		return fmt.Errorf("error opening log stream: %w", err)
 	}
 	defer podLogs.Close()
 
-	var logEntries []LogEntry
-	reader := bufio.NewReader(podLogs)
+	// Lines are read in the background so that a followed stream can emit
+	// a multi-line event once no more lines arrive for it
+	type readResult struct {
+		line string
+		err  error
+	}
+	lines := make(chan readResult)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		reader := bufio.NewReader(podLogs)
+		for {
+			line, err := reader.ReadString('\n')
+			select {
+			case lines <- readResult{line, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
 
-	// Compile regex pattern if provided
-	var re *regexp.Regexp
-	if opts.Pattern != "" {
-		re, err = regexp.Compile(opts.Pattern)
-		if err != nil {
-			return nil, fmt.Errorf("invalid regex pattern: %v", err)
+	emit := func(event *logEvent) error {
+		if event == nil {
+			return nil
 		}
+
+		// Parse log entry
+		entry := parseLogEntry(event, parser, opts.Pod, opts.Container, opts.Namespace)
+		entry.Message = lm.redactor.String(ctx, entry.Message)
+		lm.redactor.Fields(ctx, entry.Fields)
+
+		// Filter by pattern and log level if provided; patterns match
+		// the message followed by its structured fields
+		matched := (re == nil || re.MatchString(entry.Message+formatFields(entry.Fields))) &&
+			(opts.LogLevel == "" || strings.EqualFold(entry.LogLevel, opts.LogLevel)) &&
+			(opts.Query == nil || opts.Query.Match(entry))
+		return fn(entry, matched)
+	}
+
+	var flushTimer *time.Timer
+	var flush <-chan time.Time
+	if opts.Follow {
+		flushTimer = time.NewTimer(multilineFlushInterval)
+		flushTimer.Stop()
+		defer flushTimer.Stop()
 	}
 
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
+		select {
+		case <-flush:
+			flush = nil
+			if err := emit(grouper.flush()); err != nil {
+				return err
 			}
-			return nil, fmt.Errorf("error reading logs: %v", err)
-		}
 
-		// Parse log entry
-		entry := parseLogEntry(line, opts.Pod, opts.Container, opts.Namespace)
+		case result := <-lines:
+			if result.err != nil && result.err != io.EOF {
+				if ctx.Err() != nil {
+					// The caller stopped the stream
+					return nil
+				}
+				return fmt.Errorf("error reading logs: %v", result.err)
+			}
 
-		// Filter by pattern if provided
-		if re != nil && !re.MatchString(entry.Message) {
-			continue
-		}
+			if result.line != "" {
+				timestamp, line := splitTimestamp(result.line)
+				if err := emit(grouper.add(timestamp, line)); err != nil {
+					return err
+				}
+				if flushTimer != nil {
+					flushTimer.Reset(multilineFlushInterval)
+					flush = flushTimer.C
+				}
+			}
 
-		// Filter by log level if provided
-		if opts.LogLevel != "" && !strings.EqualFold(entry.LogLevel, opts.LogLevel) {
-			continue
+			if result.err == io.EOF {
+				return emit(grouper.flush())
+			}
 		}
-
-		logEntries = append(logEntries, entry)
 	}
-
-	return logEntries, nil
 }
 
-// parseLogEntry parses a log line into a structured LogEntry
-func parseLogEntry(line, pod, container, namespace string) LogEntry {
-	// Default timestamp to now
-	timestamp := time.Now()
+// appTimestampPattern matches a timestamp embedded in a log message, with
+// optional fractional seconds and time zone
+var appTimestampPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`)
 
-	// Try to extract timestamp from the log line
-	// This is a simple implementation and might need to be adjusted based on actual log format
-	timestampRegex := regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`)
-	if match := timestampRegex.FindString(line); match != "" {
-		if t, err := time.Parse(time.RFC3339, match); err == nil {
-			timestamp = t
-		}
-	}
+// appTimestampLayouts are tried in order to parse an embedded timestamp;
+// timestamps without a zone are taken as UTC
+var appTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
 
-	// Try to extract log level
-	logLevel := ""
-	logLevelRegex := regexp.MustCompile(`(?i)\b(info|error|warn|debug|fatal)\b`)
-	if match := logLevelRegex.FindString(line); match != "" {
-		logLevel = strings.ToUpper(match)
+// splitTimestamp splits the RFC3339Nano prefix the kubelet adds to every
+// line, which is the authoritative time of the entry. Lines without it,
+// which should not happen, are stamped with the retrieval time.
+func splitTimestamp(line string) (time.Time, string) {
+	line = strings.TrimRight(line, "\r\n")
+	if prefix, rest, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, prefix); err == nil {
+			return t, rest
+		}
 	}
+	return time.Now(), line
+}
 
-	return LogEntry{
-		Timestamp: timestamp,
-		Message:   strings.TrimSpace(line),
+// parseLogEntry parses a log event into a structured LogEntry. The parser
+// extracts the message, level, fields and any timestamp written by the
+// application from the first line; continuation lines such as stack frames
+// are appended to the message.
+func parseLogEntry(event *logEvent, parser Parser, pod, container, namespace string) LogEntry {
+	first := event.lines[0]
+	entry := LogEntry{
+		Timestamp: event.timestamp,
+		Message:   strings.TrimSpace(first),
 		Pod:       pod,
 		Container: container,
 		Namespace: namespace,
-		LogLevel:  logLevel,
-	}
-}
-
-// ExportLogs exports logs in the specified format
-func (lm *LogManager) ExportLogs(entries []LogEntry, format string, writer io.Writer) error {
-	switch strings.ToLower(format) {
-	case "json":
-		return json.NewEncoder(writer).Encode(entries)
-	case "csv":
-		return exportToCSV(entries, writer)
-	case "ndjson":
-		return exportToNDJSON(entries, writer)
-	case "plaintext", "text":
-		return exportToPlaintext(entries, writer)
-	default:
-		return fmt.Errorf("unsupported export format: %s", format)
-	}
-}
-
-// exportToCSV exports logs to CSV format
-func exportToCSV(entries []LogEntry, writer io.Writer) error {
-	csvWriter := csv.NewWriter(writer)
-	defer csvWriter.Flush()
-
-	// Write header
-	if err := csvWriter.Write([]string{"Timestamp", "Pod", "Container", "Namespace", "Level", "Message"}); err != nil {
-		return fmt.Errorf("error writing CSV header: %v", err)
-	}
-
-	// Write data
-	for _, entry := range entries {
-		if err := csvWriter.Write([]string{
-			entry.Timestamp.Format(time.RFC3339),
-			entry.Pod,
-			entry.Container,
-			entry.Namespace,
-			entry.LogLevel,
-			entry.Message,
-		}); err != nil {
-			return fmt.Errorf("error writing CSV record: %v", err)
-		}
 	}
 
-	return nil
+	if parsed, ok := parser.Parse(first, event.timestamp); ok {
+		entry.Message = strings.TrimSpace(parsed.Message)
+		entry.LogLevel = parsed.Level
+		entry.AppTimestamp = parsed.Timestamp
+		entry.Fields = parsed.Fields
+	}
+
+	if len(event.lines) > 1 {
+		entry.Message = strings.TrimRight(entry.Message+"\n"+strings.Join(event.lines[1:], "\n"), " \t\n")
+	}
+	if entry.LogLevel == "" {
+		entry.LogLevel = traceLevel(first)
+	}
+
+	return entry
 }
 
-// exportToNDJSON exports logs to NDJSON format
-func exportToNDJSON(entries []LogEntry, writer io.Writer) error {
-	for _, entry := range entries {
-		if err := json.NewEncoder(writer).Encode(entry); err != nil {
-			return fmt.Errorf("error writing NDJSON record: %v", err)
+// parseAppTimestamp parses a timestamp written by an application
+func parseAppTimestamp(value string) *time.Time {
+	value = strings.Replace(value, ",", ".", 1)
+	if len(value) > 10 && value[4] == '/' && value[7] == '/' {
+		value = value[:4] + "-" + value[5:7] + "-" + value[8:]
+	}
+	for _, layout := range appTimestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
 		}
 	}
 	return nil
 }
 
-// exportToPlaintext exports logs to plaintext format
-func exportToPlaintext(entries []LogEntry, writer io.Writer) error {
-	for _, entry := range entries {
-		line := fmt.Sprintf("[%s] [%s] [%s/%s] [%s] %s\n",
-			entry.Timestamp.Format(time.RFC3339),
-			entry.Namespace,
-			entry.Pod,
-			entry.Container,
-			entry.LogLevel,
-			entry.Message)
-		if _, err := writer.Write([]byte(line)); err != nil {
-			return fmt.Errorf("error writing plaintext record: %v", err)
+// formatFields renders structured fields as sorted logfmt pairs, prefixed
+// with a space, for plaintext output
+func formatFields(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, key := range sortedKeys(fields) {
+		value, ok := fields[key].(string)
+		if !ok {
+			encoded, _ := json.Marshal(fields[key])
+			value = string(encoded)
 		}
+		fmt.Fprintf(&b, " %s=%s", key, logfmtValue(value))
 	}
-	return nil
+	return b.String()
+}
+
+// logfmtValue quotes a logfmt value when it is empty or holds spaces,
+// quotes, equal signs or control characters
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"") || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
 }
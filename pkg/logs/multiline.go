@@ -0,0 +1,141 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Multiline grouping modes
+const (
+	// MultilineAuto groups the stack traces of Java, Python and Go, and any
+	// indented continuation line, with the line that precedes them
+	MultilineAuto = "auto"
+	// MultilineNone returns every line as its own entry
+	MultilineNone = "none"
+)
+
+// maxEventLines bounds the lines grouped into a single event
+const maxEventLines = 1000
+
+// multilineFlushInterval is how long a followed stream waits for more
+// lines of an event before emitting it
+const multilineFlushInterval = time.Second
+
+var (
+	// continuationPatterns match lines that continue the previous event
+	continuationPatterns = []*regexp.Regexp{
+		// Indented lines: Java "\tat ...", Python "  File ...", Go "\t/src/main.go:12 +0x1d"
+		regexp.MustCompile(`^\s`),
+		// Java exception headers, causes and elided frames
+		regexp.MustCompile(`^(?:[a-zA-Z_$][\w$]*\.)+[\w$]*(?:Exception|Error|Throwable)\b`),
+		regexp.MustCompile(`^Caused by: `),
+		regexp.MustCompile(`^Suppressed: `),
+		// Python exception line ending a traceback and chained tracebacks
+		regexp.MustCompile(`^(?:[a-zA-Z_][\w]*\.)*[A-Z][\w]*(?:Error|Exception|Warning|Exit|Interrupt)(?::|$)`),
+		regexp.MustCompile(`^During handling of the above exception`),
+		regexp.MustCompile(`^The above exception was the direct cause`),
+		// Go goroutine dumps: headers, function frames and creators
+		regexp.MustCompile(`^goroutine \d+ \[`),
+		regexp.MustCompile(`^\[(?:recovered|signal) `),
+		regexp.MustCompile(`^created by `),
+		regexp.MustCompile(`^(?:[\w.-]+/)*[\w-]+\.(?:\(\*?[\w]+\)\.)?[\w.]+\(.*\)$`),
+		regexp.MustCompile(`^exit status \d+$`),
+	}
+
+	// traceStartPatterns match lines that open a stack trace printed on its
+	// own, with the level the event is given
+	traceStartPatterns = []struct {
+		pattern *regexp.Regexp
+		level   string
+	}{
+		{regexp.MustCompile(`^Traceback \(most recent call last\):`), "ERROR"},
+		{regexp.MustCompile(`^panic: `), "FATAL"},
+		{regexp.MustCompile(`^fatal error: `), "FATAL"},
+		{regexp.MustCompile(`^Exception in thread "`), "ERROR"},
+	}
+)
+
+// eventGrouper joins the lines of multi-line events. A line either starts a
+// new event, completing the pending one, or is appended to it.
+type eventGrouper struct {
+	startsEvent func(line string) bool
+	pending     *logEvent
+}
+
+// logEvent is a group of lines forming one log entry
+type logEvent struct {
+	timestamp time.Time
+	lines     []string
+}
+
+// newEventGrouper creates a grouper for a multiline mode. A start pattern,
+// if given, overrides the mode: lines matching it start a new event and all
+// others continue the previous one.
+func newEventGrouper(mode, start string) (*eventGrouper, error) {
+	if start != "" {
+		re, err := regexp.Compile(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multiline start pattern: %v", err)
+		}
+		return &eventGrouper{startsEvent: re.MatchString}, nil
+	}
+
+	switch strings.ToLower(mode) {
+	case "", MultilineAuto:
+		return &eventGrouper{startsEvent: startsEventAuto}, nil
+	case MultilineNone:
+		return &eventGrouper{startsEvent: func(string) bool { return true }}, nil
+	default:
+		return nil, fmt.Errorf("unsupported multiline mode: %s", mode)
+	}
+}
+
+// startsEventAuto applies the built-in stack trace rules
+func startsEventAuto(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		// Blank lines separate parts of Go and Python traces
+		return false
+	}
+	for _, start := range traceStartPatterns {
+		if start.pattern.MatchString(line) {
+			return true
+		}
+	}
+	for _, pattern := range continuationPatterns {
+		if pattern.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds a line and returns the event it completed, if any
+func (g *eventGrouper) add(timestamp time.Time, line string) *logEvent {
+	if g.pending != nil && len(g.pending.lines) < maxEventLines && !g.startsEvent(line) {
+		g.pending.lines = append(g.pending.lines, line)
+		return nil
+	}
+
+	completed := g.pending
+	g.pending = &logEvent{timestamp: timestamp, lines: []string{line}}
+	return completed
+}
+
+// flush returns the pending event, if any
+func (g *eventGrouper) flush() *logEvent {
+	completed := g.pending
+	g.pending = nil
+	return completed
+}
+
+// traceLevel returns the level of an event opening with a bare stack trace
+func traceLevel(line string) string {
+	for _, start := range traceStartPatterns {
+		if start.pattern.MatchString(line) {
+			return start.level
+		}
+	}
+	return ""
+}
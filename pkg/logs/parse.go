@@ -0,0 +1,433 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultParser is used when no parser is requested
+const DefaultParser = "auto"
+
+// ParsedLine is the structure a Parser extracts from a log message
+type ParsedLine struct {
+	// Message is the human readable message, without the structure
+	Message string
+	// Level is the normalized log level, e.g. INFO or ERROR
+	Level string
+	// Timestamp is the time written by the application, if any
+	Timestamp *time.Time
+	// Fields holds the remaining structured attributes
+	Fields map[string]interface{}
+}
+
+// Parser extracts structure from the message of a log line. Parse returns
+// false when the message is not in the parser's format. The time the line
+// was received is passed for formats that omit parts of the date.
+type Parser interface {
+	Name() string
+	Parse(message string, received time.Time) (*ParsedLine, bool)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = make(map[string]Parser)
+)
+
+func init() {
+	RegisterParser(jsonParser{})
+	RegisterParser(logfmtParser{})
+	RegisterParser(klogParser{})
+	RegisterParser(commonParser{})
+	RegisterParser(plainParser{})
+	RegisterParser(autoParser{candidates: []string{"json", "logfmt", "klog", "common"}})
+}
+
+// RegisterParser makes a parser available by name, replacing any parser
+// registered under the same name
+func RegisterParser(p Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	parsers[p.Name()] = p
+}
+
+// GetParser returns the parser registered under name; an empty name selects
+// DefaultParser
+func GetParser(name string) (Parser, error) {
+	if name == "" {
+		name = DefaultParser
+	}
+
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	p, ok := parsers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported log parser: %s", name)
+	}
+	return p, nil
+}
+
+// ParserNames returns the names of the registered parsers, sorted
+func ParserNames() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// autoParser detects the format of each line by trying other parsers in
+// order, falling back to plain text
+type autoParser struct {
+	candidates []string
+}
+
+func (autoParser) Name() string { return "auto" }
+
+func (p autoParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	for _, name := range p.candidates {
+		candidate, err := GetParser(name)
+		if err != nil {
+			continue
+		}
+		if parsed, ok := candidate.Parse(message, received); ok {
+			return parsed, true
+		}
+	}
+	return plainParser{}.Parse(message, received)
+}
+
+// plainParser treats the message as opaque text and only looks for an
+// unambiguous level marker
+type plainParser struct{}
+
+// plainLevelPattern matches a level written as an upper-case keyword,
+// optionally bracketed, or as a level=... attribute. Lower-case words in
+// prose such as "no error occurred" are deliberately not matched.
+var plainLevelPattern = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|CRITICAL|FATAL|PANIC)\b|(?i:\blevel[=:]\s*"?(\w+))`)
+
+func (plainParser) Name() string { return "plain" }
+
+func (plainParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	parsed := &ParsedLine{Message: message}
+	if match := plainLevelPattern.FindStringSubmatch(message); match != nil {
+		level := match[1]
+		if level == "" {
+			level = match[2]
+		}
+		parsed.Level = normalizeLevel(level)
+	}
+	if match := appTimestampPattern.FindString(message); match != "" {
+		parsed.Timestamp = parseAppTimestamp(match)
+	}
+	return parsed, true
+}
+
+// Keys conventionally holding the message, level and time of a structured
+// log record, in order of preference
+var (
+	messageKeys   = []string{"msg", "message", "log", "event"}
+	levelKeys     = []string{"level", "lvl", "severity", "log.level", "loglevel", "levelname"}
+	timestampKeys = []string{"time", "ts", "timestamp", "@timestamp", "t", "datetime", "asctime"}
+)
+
+// jsonParser parses lines holding a JSON object, as written by zap,
+// logrus, slog, pino, bunyan and most structured loggers
+type jsonParser struct{}
+
+func (jsonParser) Name() string { return "json" }
+
+func (jsonParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	trimmed := strings.TrimSpace(message)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, false
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &fields); err != nil {
+		return nil, false
+	}
+	return structuredLine(message, fields), true
+}
+
+// logfmtParser parses key=value lines as written by logrus, go-kit and
+// slog's text handler
+type logfmtParser struct{}
+
+func (logfmtParser) Name() string { return "logfmt" }
+
+func (logfmtParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	fields, ok := parseLogfmt(message)
+	if !ok || len(fields) < 2 {
+		return nil, false
+	}
+
+	record := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		record[key] = value
+	}
+	return structuredLine(message, record), true
+}
+
+// parseLogfmt splits a line into key=value pairs. Values may be quoted. It
+// fails on any token that is not a pair, so prose is never mistaken for
+// logfmt.
+func parseLogfmt(line string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	i := 0
+	for {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '"' {
+			i++
+		}
+		if i >= len(line) || line[i] != '=' || i == start {
+			return nil, false
+		}
+		key := line[start:i]
+		i++
+
+		var value string
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			unquoted, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			value = unquoted
+			i = end + 1
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			value = line[start:i]
+		}
+
+		fields[key] = value
+	}
+	return fields, true
+}
+
+// structuredLine extracts the message, level and time of a structured record
+// and keeps the remaining attributes as fields
+func structuredLine(raw string, record map[string]interface{}) *ParsedLine {
+	parsed := &ParsedLine{Message: strings.TrimSpace(raw)}
+
+	if key, value := takeKey(record, messageKeys); key != "" {
+		if msg, ok := value.(string); ok {
+			parsed.Message = msg
+		} else {
+			record[key] = value
+		}
+	}
+
+	if _, value := takeKey(record, levelKeys); value != nil {
+		parsed.Level = normalizeLevel(fmt.Sprint(value))
+	}
+
+	if key, value := takeKey(record, timestampKeys); key != "" {
+		if t := parseTimestampValue(value); t != nil {
+			parsed.Timestamp = t
+		} else {
+			record[key] = value
+		}
+	}
+
+	if len(record) > 0 {
+		parsed.Fields = record
+	}
+	return parsed
+}
+
+// takeKey removes and returns the first of keys present in record
+func takeKey(record map[string]interface{}, keys []string) (string, interface{}) {
+	for _, key := range keys {
+		if value, ok := record[key]; ok {
+			delete(record, key)
+			return key, value
+		}
+	}
+	return "", nil
+}
+
+// parseTimestampValue parses a structured timestamp, either a string or a
+// Unix time in seconds or milliseconds
+func parseTimestampValue(value interface{}) *time.Time {
+	switch v := value.(type) {
+	case string:
+		if t := parseAppTimestamp(v); t != nil {
+			return t
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseTimestampValue(f)
+		}
+	case float64:
+		var t time.Time
+		if v > 1e12 {
+			t = time.UnixMilli(int64(v)).UTC()
+		} else {
+			sec := int64(v)
+			t = time.Unix(sec, int64((v-float64(sec))*1e9)).UTC()
+		}
+		return &t
+	}
+	return nil
+}
+
+// klogParser parses the glog/klog header used by Kubernetes components:
+// Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+type klogParser struct{}
+
+var klogPattern = regexp.MustCompile(`^([IWEF])(\d{2})(\d{2}) (\d{2}:\d{2}:\d{2}\.\d{6})\s+(\d+) ([^:\]\s]+):(\d+)\] (.*)$`)
+
+// klogLevels maps the klog severity letter to a level
+var klogLevels = map[string]string{"I": "INFO", "W": "WARN", "E": "ERROR", "F": "FATAL"}
+
+func (klogParser) Name() string { return "klog" }
+
+func (klogParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	match := klogPattern.FindStringSubmatch(strings.TrimSpace(message))
+	if match == nil {
+		return nil, false
+	}
+
+	parsed := &ParsedLine{
+		Message: match[8],
+		Level:   klogLevels[match[1]],
+		Fields: map[string]interface{}{
+			"thread": match[5],
+			"caller": match[6] + ":" + match[7],
+		},
+	}
+
+	// klog omits the year; take it from the time the line was received,
+	// stepping back a year across New Year
+	if t, err := time.Parse("2006 0102 15:04:05.000000", fmt.Sprintf("%d %s%s %s", received.Year(), match[2], match[3], match[4])); err == nil {
+		if t.After(received.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		parsed.Timestamp = &t
+	}
+
+	return parsed, true
+}
+
+// commonParser recognizes the default line layouts of popular logging
+// libraries: Java (logback, log4j), Python logging, zap's console encoder,
+// Go's standard log package and plain "time LEVEL message" lines
+type commonParser struct{}
+
+const (
+	commonTimestamp = `\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`
+	commonLevels    = `TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|CRITICAL|FATAL|PANIC|DPANIC|trace|debug|info|warn|warning|error|fatal|panic`
+)
+
+// commonPatterns are tried in order; named groups time, level, logger,
+// thread, caller and msg are extracted
+var commonPatterns = []*regexp.Regexp{
+	// Java: 2024-05-01 10:00:00.123 [main] INFO  com.example.App - message
+	regexp.MustCompile(`^(?P<time>` + commonTimestamp + `)\s+(?:\[(?P<thread>[^\]]+)\]\s+)?(?P<level>` + commonLevels + `)\s+(?:\d+\s+---\s+)?(?:\[\s*(?P<thread>[^\]]+)\]\s+)?(?P<logger>[\w.$]+)\s+(?:-|:)\s+(?P<msg>.*)$`),
+	// Python: 2024-05-01 10:00:00,123 - app.module - INFO - message
+	regexp.MustCompile(`^(?P<time>` + commonTimestamp + `)\s+-\s+(?P<logger>\S+)\s+-\s+(?P<level>` + commonLevels + `)\s+-\s+(?P<msg>.*)$`),
+	// Python basicConfig: INFO:app.module:message
+	regexp.MustCompile(`^(?P<level>DEBUG|INFO|WARNING|ERROR|CRITICAL):(?P<logger>[^:\s]+):(?P<msg>.*)$`),
+	// zap console: 2024-05-01T10:00:00.000Z	INFO	pkg/file.go:42	message
+	regexp.MustCompile(`^(?P<time>` + commonTimestamp + `)\t(?P<level>` + commonLevels + `)\t(?:(?P<caller>\S+:\d+)\t)?(?P<msg>.*)$`),
+	// Generic: 2024-05-01T10:00:00Z [ERROR] message
+	regexp.MustCompile(`^(?P<time>` + commonTimestamp + `)\s+\[?(?P<level>` + commonLevels + `)\]?:?\s+(?P<msg>.*)$`),
+	// Go log: 2024/05/01 10:00:00 message
+	regexp.MustCompile(`^(?P<time>\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+(?:(?P<caller>\S+\.go:\d+):\s+)?(?P<msg>.*)$`),
+	// Bracketed level: [WARN] message
+	regexp.MustCompile(`^\[(?P<level>` + commonLevels + `)\]\s+(?P<msg>.*)$`),
+}
+
+func (commonParser) Name() string { return "common" }
+
+func (commonParser) Parse(message string, received time.Time) (*ParsedLine, bool) {
+	trimmed := strings.TrimSpace(message)
+	for _, pattern := range commonPatterns {
+		match := pattern.FindStringSubmatch(trimmed)
+		if match == nil {
+			continue
+		}
+
+		parsed := &ParsedLine{}
+		fields := make(map[string]interface{})
+		for i, name := range pattern.SubexpNames() {
+			if name == "" || match[i] == "" {
+				continue
+			}
+			switch name {
+			case "msg":
+				parsed.Message = match[i]
+			case "level":
+				parsed.Level = normalizeLevel(match[i])
+			case "time":
+				parsed.Timestamp = parseAppTimestamp(match[i])
+			default:
+				fields[name] = strings.TrimSpace(match[i])
+			}
+		}
+
+		// zap's console encoder appends the context as a JSON object
+		if i := strings.LastIndex(parsed.Message, "\t{"); i >= 0 {
+			var context map[string]interface{}
+			if err := json.Unmarshal([]byte(parsed.Message[i+1:]), &context); err == nil {
+				for key, value := range context {
+					fields[key] = value
+				}
+				parsed.Message = parsed.Message[:i]
+			}
+		}
+
+		if len(fields) > 0 {
+			parsed.Fields = fields
+		}
+		return parsed, true
+	}
+	return nil, false
+}
+
+// normalizeLevel maps the many spellings of log levels, including numeric
+// pino/bunyan levels, onto DEBUG, INFO, WARN, ERROR and FATAL
+func normalizeLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace", "debug", "dbg", "10", "20":
+		return "DEBUG"
+	case "info", "information", "notice", "inf", "30":
+		return "INFO"
+	case "warn", "warning", "wrn", "40":
+		return "WARN"
+	case "error", "err", "eror", "50":
+		return "ERROR"
+	case "fatal", "critical", "crit", "panic", "dpanic", "alert", "emergency", "emerg", "ftl", "60":
+		return "FATAL"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,471 @@
+package logs
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a compiled log filter such as
+//
+//	level>=warn AND status=500 AND msg~"timeout" AND NOT path="/health"
+//
+// Comparisons take the form field op value, where op is one of =, !=, >,
+// >=, <, <=, ~ (regular expression match) and !~. They are combined with
+// AND, OR, NOT and parentheses; AND binds tighter than OR.
+//
+// The fields level, msg (or message), pod, container, namespace, timestamp
+// and app_timestamp refer to the entry itself, in any case; any other name
+// refers to a parsed structured field as written, with dots descending into
+// nested objects. Levels
+// compare by severity and timestamps chronologically; other values compare
+// numerically when both sides are numbers and as strings otherwise.
+type Query struct {
+	source string
+	root   queryNode
+}
+
+// QuerySyntaxError reports an invalid query and where it went wrong
+type QuerySyntaxError struct {
+	Query string `json:"query"`
+	// Position is the 1-based character offset of the error in Query
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Position, e.Message)
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *QuerySyntaxError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// ErrorDetails returns the position of the error
+func (e *QuerySyntaxError) ErrorDetails() interface{} {
+	return e
+}
+
+// ParseQuery compiles a query
+func ParseQuery(source string) (*Query, error) {
+	p := &queryParser{src: []rune(source), source: source}
+
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf(p.pos, "query is empty")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		if p.src[p.pos] == ')' {
+			return nil, p.errorf(p.pos, "unexpected ')' without matching '('")
+		}
+		return nil, p.errorf(p.pos, "expected AND, OR or end of query, found %q", p.word())
+	}
+
+	return &Query{source: source, root: root}, nil
+}
+
+// String returns the query source
+func (q *Query) String() string {
+	return q.source
+}
+
+// Match reports whether an entry satisfies the query
+func (q *Query) Match(entry LogEntry) bool {
+	return q.root.match(entry)
+}
+
+// queryNode is a node of a compiled query
+type queryNode interface {
+	match(entry LogEntry) bool
+}
+
+type andNode struct{ left, right queryNode }
+
+func (n andNode) match(e LogEntry) bool { return n.left.match(e) && n.right.match(e) }
+
+type orNode struct{ left, right queryNode }
+
+func (n orNode) match(e LogEntry) bool { return n.left.match(e) || n.right.match(e) }
+
+type notNode struct{ node queryNode }
+
+func (n notNode) match(e LogEntry) bool { return !n.node.match(e) }
+
+// comparisonNode compares a field with a literal value
+type comparisonNode struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// builtinQueryFields are the field names referring to the entry itself,
+// matched case-insensitively
+var builtinQueryFields = map[string]bool{
+	"level": true, "msg": true, "message": true, "pod": true, "container": true,
+	"namespace": true, "timestamp": true, "app_timestamp": true,
+}
+
+// queryOperators are the comparison operators, longest first so that >=
+// is not read as >
+var queryOperators = []string{"!=", ">=", "<=", "!~", "=", ">", "<", "~"}
+
+// levelRanks orders the normalized levels by severity
+var levelRanks = map[string]int{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3, "FATAL": 4}
+
+func (n comparisonNode) match(e LogEntry) bool {
+	actual, ok := n.fieldValue(e)
+
+	switch n.op {
+	case "~":
+		return ok && n.re.MatchString(actual)
+	case "!~":
+		return !ok || !n.re.MatchString(actual)
+	case "!=":
+		if !ok {
+			return true
+		}
+	default:
+		if !ok {
+			return false
+		}
+	}
+
+	cmp := n.compare(actual)
+	switch n.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// fieldValue returns the value of the compared field as a string
+func (n comparisonNode) fieldValue(e LogEntry) (string, bool) {
+	switch n.field {
+	case "level":
+		return e.LogLevel, e.LogLevel != ""
+	case "msg", "message":
+		return e.Message, true
+	case "pod":
+		return e.Pod, true
+	case "container":
+		return e.Container, true
+	case "namespace":
+		return e.Namespace, true
+	case "timestamp":
+		return e.Timestamp.Format(time.RFC3339Nano), true
+	case "app_timestamp":
+		if e.AppTimestamp == nil {
+			return "", false
+		}
+		return e.AppTimestamp.Format(time.RFC3339Nano), true
+	}
+
+	name := n.field
+	if len(name) > len("fields.") && strings.EqualFold(name[:len("fields.")], "fields.") {
+		name = name[len("fields."):]
+	}
+	value, ok := lookupField(e.Fields, name)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// lookupField finds a field by name, descending into nested objects for
+// dotted names that are not themselves keys
+func lookupField(fields map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := fields[name]; ok {
+		return value, true
+	}
+
+	for i := strings.IndexByte(name, '.'); i >= 0; i = nextDot(name, i) {
+		nested, ok := fields[name[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := lookupField(nested, name[i+1:]); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// nextDot returns the index of the next dot in name after i, or -1
+func nextDot(name string, i int) int {
+	next := strings.IndexByte(name[i+1:], '.')
+	if next < 0 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// compare orders a field value against the query value
+func (n comparisonNode) compare(actual string) int {
+	switch n.field {
+	case "level":
+		return levelRanks[normalizeLevel(actual)] - levelRanks[normalizeLevel(n.value)]
+	case "timestamp", "app_timestamp":
+		a, b := parseAppTimestamp(actual), parseAppTimestamp(n.value)
+		if a != nil && b != nil {
+			return a.Compare(*b)
+		}
+	}
+
+	if a, err := strconv.ParseFloat(actual, 64); err == nil {
+		if b, err := strconv.ParseFloat(n.value, 64); err == nil {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return strings.Compare(actual, n.value)
+}
+
+// queryParser is a recursive descent parser over the query source
+type queryParser struct {
+	source string
+	src    []rune
+	pos    int
+}
+
+// errorf returns a syntax error at a rune offset
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &QuerySyntaxError{Query: p.source, Position: pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// word returns the run of non-space characters at the current position,
+// for error messages
+func (p *queryParser) word() string {
+	end := p.pos
+	for end < len(p.src) && !unicode.IsSpace(p.src[end]) {
+		end++
+	}
+	return string(p.src[p.pos:end])
+}
+
+// keyword consumes a case-insensitive keyword followed by a delimiter
+func (p *queryParser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(string(p.src[p.pos:end]), kw) {
+		return false
+	}
+	if end < len(p.src) && !unicode.IsSpace(p.src[end]) && p.src[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.keyword("NOT") {
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf(p.pos, "unexpected end of query, expected a comparison")
+	}
+
+	if p.src[p.pos] == '(' {
+		open := p.pos
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, p.errorf(open, "unclosed '('")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	start := p.pos
+	for p.pos < len(p.src) && isFieldRune(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf(start, "expected a field name, found %q", p.word())
+	}
+	// Built-in fields are case-insensitive; structured field names are
+	// kept as written, since their keys are case-sensitive
+	field := string(p.src[start:p.pos])
+	if lower := strings.ToLower(field); builtinQueryFields[lower] {
+		field = lower
+	} else if lower == "and" || lower == "or" {
+		return nil, p.errorf(start, "expected a comparison before %s", strings.ToUpper(field))
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, candidate := range queryOperators {
+		if strings.HasPrefix(string(p.src[p.pos:]), candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf(p.pos, "unexpected end of query, expected an operator after %q", field)
+		}
+		return nil, p.errorf(p.pos, "expected an operator (=, !=, >, >=, <, <=, ~, !~) after %q, found %q", field, p.word())
+	}
+	p.pos += len([]rune(op))
+
+	p.skipSpace()
+	valuePos := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	node := comparisonNode{field: field, op: op, value: value}
+
+	switch {
+	case op == "~" || op == "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, p.errorf(valuePos, "invalid regular expression: %v", err)
+		}
+		node.re = re
+	case field == "level" && normalizeLevel(value) == "":
+		return nil, p.errorf(valuePos, "unknown level %q, expected debug, info, warn, error or fatal", value)
+	case (field == "timestamp" || field == "app_timestamp") && parseAppTimestamp(value) == nil:
+		return nil, p.errorf(valuePos, "invalid timestamp %q, expected RFC3339", value)
+	}
+
+	return node, nil
+}
+
+// parseValue reads a double-quoted string or a bare word ending at space
+// or a parenthesis
+func (p *queryParser) parseValue() (string, error) {
+	if p.pos >= len(p.src) {
+		return "", p.errorf(p.pos, "unexpected end of query, expected a value")
+	}
+
+	if p.src[p.pos] == '"' {
+		start := p.pos
+		var b strings.Builder
+		p.pos++
+		for p.pos < len(p.src) {
+			switch r := p.src[p.pos]; r {
+			case '"':
+				p.pos++
+				return b.String(), nil
+			case '\\':
+				// Only quotes and backslashes are escaped; other
+				// backslashes are kept for regular expressions
+				if p.pos+1 < len(p.src) && (p.src[p.pos+1] == '"' || p.src[p.pos+1] == '\\') {
+					p.pos++
+				}
+				b.WriteRune(p.src[p.pos])
+				p.pos++
+			default:
+				b.WriteRune(r)
+				p.pos++
+			}
+		}
+		return "", p.errorf(start, "unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && !unicode.IsSpace(p.src[p.pos]) && p.src[p.pos] != ')' && p.src[p.pos] != '(' {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf(start, "expected a value, found %q", string(p.src[p.pos]))
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+// isFieldRune reports whether r may appear in a field name
+func isFieldRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '@' || r == '-'
+}
@@ -0,0 +1,135 @@
+package logs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryEntry is the entry the query tests match against
+func queryEntry() LogEntry {
+	appTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return LogEntry{
+		Timestamp:    time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		AppTimestamp: &appTime,
+		Message:      "upstream timeout after 30s",
+		Pod:          "web-7d9f",
+		Container:    "app",
+		Namespace:    "prod",
+		LogLevel:     "ERROR",
+		Fields: map[string]interface{}{
+			"statusCode": 503.0,
+			"requestId":  "req-42",
+			"userID":     "7",
+			"path":       "/api/orders",
+			"retries":    "3",
+			"http":       map[string]interface{}{"method": "GET", "status": 503.0},
+			"level":      "custom",
+		},
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	entry := queryEntry()
+
+	for _, tc := range []struct {
+		query string
+		want  bool
+	}{
+		// Structured fields keep their case
+		{query: "statusCode=503", want: true},
+		{query: "statusCode>=500", want: true},
+		{query: "requestId=req-42", want: true},
+		{query: "userID=7", want: true},
+		{query: "fields.userID=7", want: true},
+		{query: "Fields.requestId=req-42", want: true},
+		{query: "statuscode=503", want: false},
+		{query: "requestid=req-42", want: false},
+		// Built-in fields do not
+		{query: "LEVEL=error", want: true},
+		{query: "Msg~timeout", want: true},
+		{query: "Namespace=prod AND POD=web-7d9f AND container=app", want: true},
+		{query: "fields.level=custom", want: true},
+
+		// Operators
+		{query: "level>=warn", want: true},
+		{query: "level<error", want: false},
+		{query: "level=err", want: true},
+		{query: "statusCode!=503", want: false},
+		{query: "statusCode>503", want: false},
+		{query: "statusCode<1000", want: true},
+		{query: "statusCode<=503", want: true},
+		{query: "retries>20", want: false},
+		{query: "path>/api", want: true},
+		{query: `msg~"time(out)?"`, want: true},
+		{query: `msg!~"^upstream"`, want: false},
+		{query: `path="/api/orders"`, want: true},
+		{query: "http.status=503 AND http.method=GET", want: true},
+		{query: "timestamp>2024-05-01T12:00:00Z", want: true},
+		{query: "app_timestamp<2024-05-01T12:00:00Z", want: false},
+		// Missing fields only satisfy negations
+		{query: "missing=1", want: false},
+		{query: "missing!=1", want: true},
+		{query: "missing~.", want: false},
+		{query: "missing!~.", want: true},
+
+		// AND binds tighter than OR, NOT tighter than both
+		{query: "pod=other OR level=error AND namespace=prod", want: true},
+		{query: "(pod=other OR level=error) AND namespace=dev", want: false},
+		{query: "pod=other OR level=error AND namespace=dev", want: false},
+		{query: "NOT pod=other AND level=error", want: true},
+		{query: "NOT (pod=web-7d9f OR level=info)", want: false},
+		{query: "NOT NOT level=error", want: true},
+		{query: "level=error and not msg~health or pod=x", want: true},
+	} {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.query, err)
+			continue
+		}
+		if got := q.Match(entry); got != tc.want {
+			t.Errorf("ParseQuery(%q).Match() = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestQuerySyntaxErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		position int
+		message  string
+	}{
+		{query: "", position: 1, message: "query is empty"},
+		{query: "   ", position: 4, message: "query is empty"},
+		{query: "level>=", position: 8, message: "expected a value"},
+		{query: "level>=bogus", position: 8, message: "unknown level"},
+		{query: "(level=info", position: 1, message: "unclosed '('"},
+		{query: "level=info)", position: 11, message: "unexpected ')'"},
+		{query: `msg~"("`, position: 5, message: "invalid regular expression"},
+		{query: `msg "x"`, position: 5, message: "expected an operator"},
+		{query: "msg", position: 4, message: "expected an operator"},
+		{query: "a=1 b=2", position: 5, message: "expected AND, OR or end of query"},
+		{query: "AND a=1", position: 1, message: "expected a comparison before AND"},
+		{query: "a=1 OR", position: 7, message: "expected a comparison"},
+		{query: `msg="abc`, position: 5, message: "unterminated string"},
+		{query: "timestamp>yesterday", position: 11, message: "invalid timestamp"},
+		{query: "=1", position: 1, message: "expected a field name"},
+		// Positions count characters, not bytes
+		{query: "héllo=1 AND", position: 12, message: "expected a comparison"},
+	} {
+		_, err := ParseQuery(tc.query)
+		var syntaxErr *QuerySyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseQuery(%q) = %v, want a QuerySyntaxError", tc.query, err)
+			continue
+		}
+		if syntaxErr.Position != tc.position || !strings.Contains(syntaxErr.Message, tc.message) {
+			t.Errorf("ParseQuery(%q) error at %d: %q, want %q at %d",
+				tc.query, syntaxErr.Position, syntaxErr.Message, tc.message, tc.position)
+		}
+		if syntaxErr.Query != tc.query || syntaxErr.StatusCode() != 400 {
+			t.Errorf("ParseQuery(%q) error %+v does not carry the query and status 400", tc.query, syntaxErr)
+		}
+	}
+}
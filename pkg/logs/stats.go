@@ -0,0 +1,180 @@
+package logs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBucketWidth is the width of histogram buckets when none is given
+const DefaultBucketWidth = time.Minute
+
+// maxHistogramBuckets bounds the buckets returned; wider spans get wider
+// buckets
+const maxHistogramBuckets = 1000
+
+// unknownLevel counts entries without a recognized level
+const unknownLevel = "UNKNOWN"
+
+// LogStats summarizes a stream of log entries
+type LogStats struct {
+	Total int        `json:"total"`
+	First *time.Time `json:"first,omitempty"`
+	Last  *time.Time `json:"last,omitempty"`
+	// ByLevel counts entries per level; entries without a level are
+	// counted as UNKNOWN
+	ByLevel map[string]int `json:"by_level"`
+	// BySource counts entries per container, noisiest first
+	BySource []SourceCount `json:"by_source"`
+	// BucketWidth is the width of the histogram buckets as a Go duration
+	BucketWidth string `json:"bucket_width"`
+	// Histogram counts entries over time, from the bucket holding First to
+	// the bucket holding Last, including empty buckets
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+// SourceCount is the number of entries written by one container
+type SourceCount struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// HistogramBucket counts the entries in a time interval
+type HistogramBucket struct {
+	Start   time.Time      `json:"start"`
+	Total   int            `json:"total"`
+	ByLevel map[string]int `json:"by_level,omitempty"`
+}
+
+// StatsAggregator accumulates LogStats. It is safe for concurrent use.
+type StatsAggregator struct {
+	mu      sync.Mutex
+	width   time.Duration
+	total   int
+	first   time.Time
+	last    time.Time
+	levels  map[string]int
+	sources map[PodTarget]int
+	buckets map[int64]map[string]int
+}
+
+// NewStatsAggregator creates an aggregator with histogram buckets of the
+// given width, or DefaultBucketWidth
+func NewStatsAggregator(width time.Duration) *StatsAggregator {
+	if width <= 0 {
+		width = DefaultBucketWidth
+	}
+	return &StatsAggregator{
+		width:   width,
+		levels:  make(map[string]int),
+		sources: make(map[PodTarget]int),
+		buckets: make(map[int64]map[string]int),
+	}
+}
+
+// Add counts an entry
+func (a *StatsAggregator) Add(entry LogEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	level := entry.LogLevel
+	if level == "" {
+		level = unknownLevel
+	}
+
+	a.total++
+	if a.first.IsZero() || entry.Timestamp.Before(a.first) {
+		a.first = entry.Timestamp
+	}
+	if entry.Timestamp.After(a.last) {
+		a.last = entry.Timestamp
+	}
+	a.levels[level]++
+	a.sources[PodTarget{Pod: entry.Pod, Container: entry.Container}]++
+
+	bucket := a.bucketIndex(entry.Timestamp)
+	if a.buckets[bucket] == nil {
+		a.buckets[bucket] = make(map[string]int)
+	}
+	a.buckets[bucket][level]++
+}
+
+// bucketIndex returns the index of the bucket holding t
+func (a *StatsAggregator) bucketIndex(t time.Time) int64 {
+	return t.UnixNano() / int64(a.width)
+}
+
+// Result returns the statistics accumulated so far
+func (a *StatsAggregator) Result() *LogStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := &LogStats{
+		Total:       a.total,
+		ByLevel:     make(map[string]int, len(a.levels)),
+		BySource:    make([]SourceCount, 0, len(a.sources)),
+		BucketWidth: a.width.String(),
+		Histogram:   []HistogramBucket{},
+	}
+	for level, count := range a.levels {
+		stats.ByLevel[level] = count
+	}
+	for source, count := range a.sources {
+		stats.BySource = append(stats.BySource, SourceCount{Pod: source.Pod, Container: source.Container, Count: count})
+	}
+	sort.Slice(stats.BySource, func(i, j int) bool {
+		if stats.BySource[i].Count != stats.BySource[j].Count {
+			return stats.BySource[i].Count > stats.BySource[j].Count
+		}
+		if stats.BySource[i].Pod != stats.BySource[j].Pod {
+			return stats.BySource[i].Pod < stats.BySource[j].Pod
+		}
+		return stats.BySource[i].Container < stats.BySource[j].Container
+	})
+
+	if a.total == 0 {
+		return stats
+	}
+
+	first, last := a.first, a.last
+	stats.First, stats.Last = &first, &last
+
+	// Merge buckets when the span needs more than maxHistogramBuckets
+	firstBucket, lastBucket := a.bucketIndex(first), a.bucketIndex(last)
+	factor := (lastBucket-firstBucket)/maxHistogramBuckets + 1
+	width := a.width * time.Duration(factor)
+	stats.BucketWidth = width.String()
+
+	start := firstBucket - firstBucket%factor
+	for index := start; index <= lastBucket; index += factor {
+		bucket := HistogramBucket{Start: time.Unix(0, index*int64(a.width)).UTC()}
+		for i := index; i < index+factor; i++ {
+			for level, count := range a.buckets[i] {
+				if bucket.ByLevel == nil {
+					bucket.ByLevel = make(map[string]int)
+				}
+				bucket.ByLevel[level] += count
+				bucket.Total += count
+			}
+		}
+		stats.Histogram = append(stats.Histogram, bucket)
+	}
+
+	return stats
+}
+
+// GetPodStats computes statistics over the filtered logs of several pods,
+// reading at most concurrency pods at a time. Pods whose logs cannot be
+// read are reported individually.
+func (lm *LogManager) GetPodStats(ctx context.Context, opts LogOptions, targets []PodTarget, concurrency int, width time.Duration) (*LogStats, []*PodError) {
+	aggregator := NewStatsAggregator(width)
+	_, failures := fetchPods(ctx, opts, targets, concurrency, func(ctx context.Context, opts LogOptions) (struct{}, error) {
+		return struct{}{}, lm.StreamLogs(ctx, opts, func(entry LogEntry) error {
+			aggregator.Add(entry)
+			return nil
+		})
+	})
+	return aggregator.Result(), failures
+}
@@ -0,0 +1,162 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/auth"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/policy"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CanIResult is returned by the 'can_i' command
+type CanIResult struct {
+	policy.Decision
+	User string `json:"user"`
+}
+
+// SetPolicy sets the policy authorizing every command; nil allows every
+// command
+func (h *Handler) SetPolicy(p *policy.Policy) {
+	h.policy = p
+}
+
+// authorize returns a *policy.DeniedError when the caller in ctx may not run
+// the command
+func (h *Handler) authorize(ctx context.Context, cmd *Command) error {
+	if h.policy == nil {
+		return nil
+	}
+
+	attrs := h.policyAttributes(ctx, cmd)
+	if decision := h.policy.Evaluate(attrs); !decision.Allowed {
+		return &policy.DeniedError{Attributes: attrs, Decision: decision}
+	}
+	return nil
+}
+
+// revealSecrets returns a context revealing the data of Secrets when the
+// policy grants the caller the reveal command for the command's target
+func (h *Handler) revealSecrets(ctx context.Context, cmd *Command) context.Context {
+	if h.policy == nil || isLogCommand(cmd.Type) {
+		return ctx
+	}
+	if h.policy.Reveals(h.policyAttributes(ctx, cmd)).Allowed {
+		return redact.WithSecretsRevealed(ctx)
+	}
+	return ctx
+}
+
+// handleCanICommand handles the 'can_i' command, reporting whether the
+// policy allows the caller to run the command described by verb and the
+// other fields without running it
+func (h *Handler) handleCanICommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if cmd.Verb == "" {
+		return NewErrorResponse(fmt.Errorf("verb is required"))
+	}
+	if cmd.Verb == policy.RevealCommand {
+		attrs := h.policyAttributes(ctx, &Command{Type: GetCommand, Resource: "secrets", Namespace: cmd.Namespace, Name: cmd.Name})
+		result := CanIResult{Decision: h.policy.Reveals(attrs), User: attrs.User}
+		return canIResponse(result)
+	}
+	if _, ok := findTool(string(cmd.Verb)); !ok || cmd.Verb == CanICommand {
+		return NewErrorResponse(fmt.Errorf("unsupported verb: %s", cmd.Verb))
+	}
+
+	probe := *cmd
+	probe.Type = cmd.Verb
+	if isLogCommand(probe.Type) && (probe.LogOptions == nil || probe.LogOptions.Pod == "") && cmd.Name != "" {
+		probe.LogOptions = &LogOptions{Pod: cmd.Name}
+	}
+
+	attrs := h.policyAttributes(ctx, &probe)
+	result := CanIResult{Decision: h.policy.Evaluate(attrs), User: attrs.User}
+	if h.readOnly && probe.Type.Mutating() {
+		result.Decision = policy.Decision{Allowed: false, Reason: "the server is read-only"}
+	}
+
+	return canIResponse(result)
+}
+
+// canIResponse answers a 'can_i' command
+func canIResponse(result CanIResult) (*Response, error) {
+	answer := "no"
+	if result.Allowed {
+		answer = "yes"
+	}
+	return NewSuccessResponse(fmt.Sprintf("%s: %s", answer, result.Reason), result)
+}
+
+// policyAttributes describes a command and its caller for the policy.
+// Resource types are resolved to their plural name and group so rules
+// match however the caller spelled them; log commands are authorized
+// against pods/log.
+func (h *Handler) policyAttributes(ctx context.Context, cmd *Command) policy.Attributes {
+	attrs := policy.Attributes{
+		User:    policy.AnonymousUser,
+		Groups:  []string{policy.UnauthenticatedGroup},
+		Command: string(cmd.Type),
+	}
+	if identity, ok := auth.IdentityFrom(ctx); ok {
+		attrs.User = identity.Username
+		attrs.Groups = append(append([]string(nil), identity.Groups...), policy.AuthenticatedGroup)
+	}
+
+	if isLogCommand(cmd.Type) {
+		attrs.Resource = policy.LogResource
+		attrs.Namespace = cmd.Namespace
+		if cmd.LogOptions != nil {
+			attrs.Name = cmd.LogOptions.Pod
+		}
+		return attrs
+	}
+
+	if cmd.Resource == "" {
+		return attrs
+	}
+
+	attrs.Name = cmd.Name
+	namespace := cmd.Namespace
+	if cmd.Data != nil && (cmd.Type == CreateCommand || cmd.Type == UpdateCommand || cmd.Type == ApplyCommand) {
+		if obj, err := decodeObject(cmd); err == nil {
+			attrs.Name = obj.GetName()
+			if namespace == "" {
+				namespace = obj.GetNamespace()
+			}
+		}
+	}
+
+	// Unresolvable types fail when the command runs; authorize them by
+	// the name given so the denial, if any, is reported first
+	info, err := h.k8sClient.ResolveResource(cmd.Resource)
+	if err != nil {
+		attrs.Resource = strings.ToLower(cmd.Resource)
+		attrs.Namespace = namespace
+		return attrs
+	}
+
+	attrs.Resource = info.GroupVersionResource.Resource
+	attrs.Group = info.GroupVersionResource.Group
+	if info.Namespaced {
+		switch {
+		case namespace != "":
+			attrs.Namespace = namespace
+		case cmd.Type == ListCommand || cmd.Type == WatchCommand:
+			attrs.AllNamespaces = true
+		default:
+			attrs.Namespace = metav1.NamespaceDefault
+		}
+	}
+	return attrs
+}
+
+// isLogCommand reports whether a command reads pod logs
+func isLogCommand(t CommandType) bool {
+	switch t {
+	case LogsCommand, SearchLogsCommand, ExportLogsCommand, LogStatsCommand, SummarizeLogsCommand:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package mcp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/policy"
+)
+
+// confirmationTTL is how long a confirmation token returned by a dry-run
+// delete stays valid
+const confirmationTTL = 5 * time.Minute
+
+// Default protected namespaces and resources, where deletes need
+// confirmation
+var (
+	DefaultProtectedNamespaces = []string{"kube-system"}
+	DefaultProtectedResources  = []string{"namespaces", "persistentvolumes"}
+)
+
+// ReadOnlyError rejects mutating commands when the handler is read-only
+type ReadOnlyError struct {
+	Command CommandType `json:"command"`
+}
+
+// Error implements the error interface
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("command '%s' is not allowed: the server is read-only", e.Command)
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *ReadOnlyError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// ConfirmationRequiredError rejects deletes of protected objects that do
+// not carry a valid confirmation token
+type ConfirmationRequiredError struct {
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *ConfirmationRequiredError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *ConfirmationRequiredError) StatusCode() int {
+	return http.StatusPreconditionRequired
+}
+
+// ErrorDetails returns the object that needs confirmation
+func (e *ConfirmationRequiredError) ErrorDetails() interface{} {
+	return e
+}
+
+// DeleteConfirmation is returned by a dry-run delete of a protected object
+type DeleteConfirmation struct {
+	// ConfirmationToken is passed as confirmation_token to the delete
+	ConfirmationToken string    `json:"confirmation_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// SetReadOnly makes the handler reject every mutating command and hide
+// them from the tools it lists
+func (h *Handler) SetReadOnly(readOnly bool) {
+	h.readOnly = readOnly
+}
+
+// SetProtected sets the namespaces and resource types whose objects can
+// only be deleted with a confirmation token. Resource types are plural,
+// optionally qualified by group (deployments.apps).
+func (h *Handler) SetProtected(namespaces, resources []string) {
+	h.protectedNamespaces = toSet(namespaces)
+	h.protectedResources = toSet(resources)
+}
+
+// Tools returns the tools advertised to MCP clients, without the mutating
+// ones when the handler is read-only
+func (h *Handler) Tools() []Tool {
+	tools := ListTools()
+	if !h.readOnly {
+		return tools
+	}
+
+	allowed := tools[:0]
+	for _, tool := range tools {
+		if !CommandType(tool.Name).Mutating() {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+// isProtected reports whether deleting the object described by attrs needs
+// confirmation. Deleting a protected namespace itself is protected too.
+func (h *Handler) isProtected(attrs policy.Attributes) bool {
+	if h.protectedResources[attrs.Resource] || h.protectedResources[attrs.Resource+"."+attrs.Group] {
+		return true
+	}
+	if h.protectedNamespaces[attrs.Namespace] {
+		return true
+	}
+	return attrs.Resource == "namespaces" && attrs.Group == "" && h.protectedNamespaces[attrs.Name]
+}
+
+// confirmationToken returns a token allowing the caller to delete the
+// object described by attrs until it expires
+func (h *Handler) confirmationToken(attrs policy.Attributes, expires time.Time) string {
+	token := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(token, uint64(expires.Unix()))
+	token = append(token, h.confirmationMAC(attrs, token[:8])...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// confirmationMAC signs the caller, the object and the expiry of a token
+func (h *Handler) confirmationMAC(attrs policy.Attributes, expiry []byte) []byte {
+	mac := hmac.New(sha256.New, h.confirmationKey)
+	mac.Write(expiry)
+	for _, part := range []string{attrs.User, attrs.Resource, attrs.Group, attrs.Namespace, attrs.Name} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// checkConfirmation returns a ConfirmationRequiredError unless token was
+// issued to the caller for the object described by attrs and has not
+// expired
+func (h *Handler) checkConfirmation(attrs policy.Attributes, token string) error {
+	target := fmt.Sprintf("%s '%s'", attrs.Resource, attrs.Name)
+	if attrs.Namespace != "" {
+		target += fmt.Sprintf(" in namespace '%s'", attrs.Namespace)
+	}
+	confirmationErr := func(reason string) error {
+		return &ConfirmationRequiredError{
+			Resource:  attrs.Resource,
+			Namespace: attrs.Namespace,
+			Name:      attrs.Name,
+			Message: fmt.Sprintf("deleting protected %s requires confirmation: %s; run the delete with dry_run set to get a confirmation token and pass it as confirmation_token",
+				target, reason),
+		}
+	}
+
+	if token == "" {
+		return confirmationErr("no confirmation token given")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 8+sha256.Size || !hmac.Equal(raw[8:], h.confirmationMAC(attrs, raw[:8])) {
+		return confirmationErr("the confirmation token is not valid for this object")
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(raw[:8])) {
+		return confirmationErr("the confirmation token has expired")
+	}
+	return nil
+}
+
+// newConfirmationKey returns a random key signing confirmation tokens;
+// tokens do not survive restarts
+func newConfirmationKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate confirmation key: %v", err))
+	}
+	return key
+}
+
+// toSet returns the non-empty values as a set
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
@@ -2,73 +2,239 @@ package mcp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"time"
 
 	"github.com/mayukhsarkar/k8s-mcp-server/pkg/kubernetes"
 	"github.com/mayukhsarkar/k8s-mcp-server/pkg/logs"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/policy"
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/redact"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	k8s "k8s.io/client-go/kubernetes"
 )
 
+// DefaultFieldManager is the field manager used for server-side apply when
+// the command does not name one
+const DefaultFieldManager = "k8s-mcp-server"
+
+// patchTypes maps the patch_type names accepted in commands to API patch
+// types. Merge patch is the default as it works for every resource,
+// including custom resources.
+var patchTypes = map[string]types.PatchType{
+	"":          types.MergePatchType,
+	"json":      types.JSONPatchType,
+	"merge":     types.MergePatchType,
+	"strategic": types.StrategicMergePatchType,
+}
+
+// Limits applied to the 'watch' command
+const (
+	defaultWatchTimeout = time.Minute
+	maxWatchTimeout     = time.Hour
+	// maxWatchResultEvents bounds the events kept for the final response;
+	// streamed events are not affected
+	maxWatchResultEvents = 1000
+)
+
+// Limits applied when following logs
+const (
+	defaultFollowDuration = 5 * time.Minute
+	maxFollowDuration     = time.Hour
+	// maxFollowResultEntries bounds the entries kept for the final
+	// response; streamed entries are not affected
+	maxFollowResultEntries = 1000
+	// maxStreamedPods bounds the number of log streams kept open at once
+	// when following or exporting logs
+	maxStreamedPods = 50
+)
+
+// maxContextLines bounds the entries shown before and after a search match
+const maxContextLines = 1000
+
+// errWatchComplete stops a watch once the requested number of events has
+// been delivered
+var errWatchComplete = errors.New("watch complete")
+
+// WatchResult is returned by the 'watch' command
+type WatchResult struct {
+	Events []kubernetes.WatchEvent `json:"events"`
+	// Count is the number of events observed, including any not kept
+	Count int `json:"count"`
+	// Truncated is set when more events were observed than are returned
+	Truncated bool `json:"truncated,omitempty"`
+	// ResourceVersion can be passed back to resume watching
+	ResourceVersion string `json:"resource_version,omitempty"`
+}
+
+// DryRunResult is returned by writes run with dry_run set
+type DryRunResult struct {
+	// Object is the object as the API server would have persisted it
+	Object *unstructured.Unstructured `json:"object"`
+	// Diff lists the fields that would change in the live object; it is
+	// empty for objects that would be created
+	Diff []kubernetes.FieldChange `json:"diff,omitempty"`
+}
+
 // Handler handles MCP commands
 type Handler struct {
 	k8sClient  *kubernetes.Client
 	logManager *logs.LogManager
+	policy     *policy.Policy
+
+	// Guard rails: a read-only handler rejects mutating commands, and
+	// deletes in protected namespaces or of protected resource types need
+	// a token signed with confirmationKey
+	readOnly            bool
+	protectedNamespaces map[string]bool
+	protectedResources  map[string]bool
+	confirmationKey     []byte
 }
 
 // NewHandler creates a new MCP handler
 func NewHandler(k8sClient *kubernetes.Client, clientset *k8s.Clientset) *Handler {
-	return &Handler{
-		k8sClient:  k8sClient,
-		logManager: logs.NewLogManager(clientset),
+	logManager := logs.NewLogManager(clientset)
+	logManager.SetClientsetFunc(k8sClient.ClientsetFor)
+
+	h := &Handler{
+		k8sClient:       k8sClient,
+		logManager:      logManager,
+		confirmationKey: newConfirmationKey(),
+	}
+	h.SetProtected(DefaultProtectedNamespaces, DefaultProtectedResources)
+	return h
+}
+
+// SetRedactor sets the redactor applied to the resources and log entries
+// the handler returns
+func (h *Handler) SetRedactor(r *redact.Redactor) {
+	h.k8sClient.SetRedactor(r)
+	h.logManager.SetRedactor(r)
+}
+
+// HandleCommand processes an MCP command and returns a response. The context
+// bounds the lifetime of the command and carries the notifier used to report
+// progress on long-running commands. The response reports how many values
+// were redacted from it and from any events streamed before it.
+func (h *Handler) HandleCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	resp, err := countRedacted(ctx, func(ctx context.Context) (*Response, error) {
+		return h.dispatch(ctx, cmd)
+	})
+	if resp != nil && resp.Redacted > 0 && !isLogCommand(cmd.Type) {
+		resp.Warnings = append(resp.Warnings, redactedObjectsWarning)
+	}
+	return resp, err
+}
+
+// countRedacted runs fn with a context counting the values redacted under
+// it and reports the count in fn's response
+func countRedacted(ctx context.Context, fn func(context.Context) (*Response, error)) (*Response, error) {
+	ctx, redacted := redact.WithCounter(ctx)
+
+	resp, err := fn(ctx)
+	if resp != nil {
+		resp.Redacted = redacted.Count()
 	}
+	return resp, err
 }
 
-// HandleCommand processes an MCP command and returns a response
-func (h *Handler) HandleCommand(cmd *Command) (*Response, error) {
+// dispatch authorizes a command and runs it by type
+func (h *Handler) dispatch(ctx context.Context, cmd *Command) (*Response, error) {
+	if h.readOnly && cmd.Type.Mutating() {
+		return NewErrorResponse(&ReadOnlyError{Command: cmd.Type})
+	}
+	if cmd.Type != CanICommand {
+		if err := h.authorize(ctx, cmd); err != nil {
+			return NewErrorResponse(err)
+		}
+		ctx = h.revealSecrets(ctx, cmd)
+	}
+
 	switch cmd.Type {
 	case ListCommand:
-		return h.handleListCommand(cmd)
+		return h.handleListCommand(ctx, cmd)
 	case GetCommand:
-		return h.handleGetCommand(cmd)
+		return h.handleGetCommand(ctx, cmd)
 	case CreateCommand:
-		return h.handleCreateCommand(cmd)
+		return h.handleCreateCommand(ctx, cmd)
+	case UpdateCommand:
+		return h.handleUpdateCommand(ctx, cmd)
+	case PatchCommand:
+		return h.handlePatchCommand(ctx, cmd)
+	case ApplyCommand:
+		return h.handleApplyCommand(ctx, cmd)
 	case DeleteCommand:
-		return h.handleDeleteCommand(cmd)
+		return h.handleDeleteCommand(ctx, cmd)
+	case WatchCommand:
+		return h.handleWatchCommand(ctx, cmd)
 	case LogsCommand:
-		return h.handleLogsCommand(cmd)
+		return h.handleLogsCommand(ctx, cmd)
 	case SearchLogsCommand:
-		return h.handleSearchLogsCommand(cmd)
+		return h.handleSearchLogsCommand(ctx, cmd)
 	case ExportLogsCommand:
-		return h.handleExportLogsCommand(cmd)
+		return h.handleExportLogsCommand(ctx, cmd)
+	case LogStatsCommand:
+		return h.handleLogStatsCommand(ctx, cmd)
+	case SummarizeLogsCommand:
+		return h.handleSummarizeLogsCommand(ctx, cmd)
+	case CanICommand:
+		return h.handleCanICommand(ctx, cmd)
 	default:
 		return NewErrorResponse(fmt.Errorf("unsupported command type: %s", cmd.Type))
 	}
 }
 
 // handleListCommand handles the 'list' command
-func (h *Handler) handleListCommand(cmd *Command) (*Response, error) {
+func (h *Handler) handleListCommand(ctx context.Context, cmd *Command) (*Response, error) {
 	if cmd.Resource == "" {
 		return NewErrorResponse(fmt.Errorf("resource type is required"))
 	}
 
-	resources, err := h.k8sClient.ListResources(cmd.Resource, cmd.Namespace)
+	if cmd.Limit < 0 {
+		return NewErrorResponse(fmt.Errorf("limit must not be negative"))
+	}
+
+	resources, err := h.k8sClient.ListResources(ctx, cmd.Resource, cmd.Namespace, metav1.ListOptions{
+		LabelSelector: cmd.LabelSelector,
+		FieldSelector: cmd.FieldSelector,
+		Limit:         cmd.Limit,
+		Continue:      cmd.Continue,
+	})
 	if err != nil {
 		return NewErrorResponse(err)
 	}
 
-	return NewSuccessResponse(fmt.Sprintf("Successfully listed %s", cmd.Resource), resources)
+	resp, err := NewSuccessResponse(fmt.Sprintf("Successfully listed %d %s", len(resources.Items), cmd.Resource), resources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Surface the continue token so callers can fetch the next page
+	if token := resources.GetContinue(); token != "" {
+		resp.Page = &PageInfo{
+			Continue:           token,
+			RemainingItemCount: resources.GetRemainingItemCount(),
+		}
+	}
+
+	return resp, nil
 }
 
 // handleGetCommand handles the 'get' command
-func (h *Handler) handleGetCommand(cmd *Command) (*Response, error) {
+func (h *Handler) handleGetCommand(ctx context.Context, cmd *Command) (*Response, error) {
 	if cmd.Resource == "" || cmd.Name == "" {
 		return NewErrorResponse(fmt.Errorf("resource type and name are required"))
 	}
 
-	resource, err := h.k8sClient.GetResource(cmd.Resource, cmd.Namespace, cmd.Name)
+	resource, err := h.k8sClient.GetResource(ctx, cmd.Resource, cmd.Namespace, cmd.Name)
 	if err != nil {
 		return NewErrorResponse(err)
 	}
@@ -77,141 +243,722 @@ func (h *Handler) handleGetCommand(cmd *Command) (*Response, error) {
 }
 
 // handleCreateCommand handles the 'create' command
-func (h *Handler) handleCreateCommand(cmd *Command) (*Response, error) {
+func (h *Handler) handleCreateCommand(ctx context.Context, cmd *Command) (*Response, error) {
 	if cmd.Resource == "" || cmd.Data == nil {
 		return NewErrorResponse(fmt.Errorf("resource type and data are required"))
 	}
 
-	var obj unstructured.Unstructured
-	if err := json.Unmarshal(cmd.Data, &obj.Object); err != nil {
-		return NewErrorResponse(fmt.Errorf("invalid resource data: %v", err))
+	obj, err := decodeObject(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	// A dry run is redacted by dryRunResponse, as for the other writes
+	writeCtx := ctx
+	if cmd.DryRun {
+		writeCtx = redact.WithoutRedaction(ctx)
 	}
 
-	created, err := h.k8sClient.CreateResource(cmd.Resource, cmd.Namespace, &obj)
+	created, err := h.k8sClient.CreateResource(writeCtx, cmd.Resource, cmd.Namespace, obj, cmd.DryRun)
 	if err != nil {
 		return NewErrorResponse(err)
 	}
 
+	if cmd.DryRun {
+		return h.dryRunResponse(ctx, cmd.Resource, "created", nil, created)
+	}
+
 	return NewSuccessResponse(fmt.Sprintf("Successfully created %s", cmd.Resource), created)
 }
 
+// handleUpdateCommand handles the 'update' command
+func (h *Handler) handleUpdateCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if cmd.Resource == "" || cmd.Data == nil {
+		return NewErrorResponse(fmt.Errorf("resource type and data are required"))
+	}
+
+	obj, err := decodeObject(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	// A dry run is compared unredacted with the live object
+	writeCtx := ctx
+	var live *unstructured.Unstructured
+	if cmd.DryRun {
+		writeCtx = redact.WithoutRedaction(ctx)
+		if live, err = h.liveObject(writeCtx, cmd.Resource, cmd.Namespace, obj); err != nil {
+			return NewErrorResponse(err)
+		}
+	}
+
+	updated, err := h.k8sClient.UpdateResource(writeCtx, cmd.Resource, cmd.Namespace, obj, cmd.DryRun)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	if cmd.DryRun {
+		return h.dryRunResponse(ctx, cmd.Resource, "updated", live, updated)
+	}
+
+	return NewSuccessResponse(fmt.Sprintf("Successfully updated %s '%s'", cmd.Resource, obj.GetName()), updated)
+}
+
+// handlePatchCommand handles the 'patch' command
+func (h *Handler) handlePatchCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if cmd.Resource == "" || cmd.Name == "" || cmd.Data == nil {
+		return NewErrorResponse(fmt.Errorf("resource type, name and data are required"))
+	}
+
+	if err := checkMasked(cmd); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	patchType, ok := patchTypes[cmd.PatchType]
+	if !ok {
+		return NewErrorResponse(fmt.Errorf("unsupported patch type: %s (expected json, merge or strategic)", cmd.PatchType))
+	}
+
+	// A dry run is compared unredacted with the live object, which must
+	// exist
+	writeCtx := ctx
+	var live *unstructured.Unstructured
+	if cmd.DryRun {
+		var err error
+		writeCtx = redact.WithoutRedaction(ctx)
+		if live, err = h.k8sClient.GetResource(writeCtx, cmd.Resource, cmd.Namespace, cmd.Name); err != nil {
+			return NewErrorResponse(err)
+		}
+	}
+
+	patched, err := h.k8sClient.PatchResource(writeCtx, cmd.Resource, cmd.Namespace, cmd.Name, patchType, cmd.Data, cmd.DryRun)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	if cmd.DryRun {
+		return h.dryRunResponse(ctx, cmd.Resource, "patched", live, patched)
+	}
+
+	return NewSuccessResponse(fmt.Sprintf("Successfully patched %s '%s'", cmd.Resource, cmd.Name), patched)
+}
+
+// handleApplyCommand handles the 'apply' command
+func (h *Handler) handleApplyCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if cmd.Resource == "" || cmd.Data == nil {
+		return NewErrorResponse(fmt.Errorf("resource type and data are required"))
+	}
+
+	obj, err := decodeObject(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	fieldManager := cmd.FieldManager
+	if fieldManager == "" {
+		fieldManager = DefaultFieldManager
+	}
+
+	// A dry run is compared unredacted with the live object
+	writeCtx := ctx
+	var live *unstructured.Unstructured
+	if cmd.DryRun {
+		writeCtx = redact.WithoutRedaction(ctx)
+		if live, err = h.liveObject(writeCtx, cmd.Resource, cmd.Namespace, obj); err != nil {
+			return NewErrorResponse(err)
+		}
+	}
+
+	applied, err := h.k8sClient.ApplyResource(writeCtx, cmd.Resource, cmd.Namespace, obj, fieldManager, cmd.Force, cmd.DryRun)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	if cmd.DryRun {
+		return h.dryRunResponse(ctx, cmd.Resource, "applied", live, applied)
+	}
+
+	return NewSuccessResponse(fmt.Sprintf("Successfully applied %s '%s'", cmd.Resource, obj.GetName()), applied)
+}
+
 // handleDeleteCommand handles the 'delete' command
-func (h *Handler) handleDeleteCommand(cmd *Command) (*Response, error) {
+func (h *Handler) handleDeleteCommand(ctx context.Context, cmd *Command) (*Response, error) {
 	if cmd.Resource == "" || cmd.Name == "" {
 		return NewErrorResponse(fmt.Errorf("resource type and name are required"))
 	}
 
-	if err := h.k8sClient.DeleteResource(cmd.Resource, cmd.Namespace, cmd.Name); err != nil {
+	// Protected objects are only deleted with a token from a dry run
+	attrs := h.policyAttributes(ctx, cmd)
+	protected := h.isProtected(attrs)
+	if protected && !cmd.DryRun {
+		if err := h.checkConfirmation(attrs, cmd.ConfirmationToken); err != nil {
+			return NewErrorResponse(err)
+		}
+	}
+
+	if err := h.k8sClient.DeleteResource(ctx, cmd.Resource, cmd.Namespace, cmd.Name, cmd.DryRun); err != nil {
 		return NewErrorResponse(err)
 	}
 
-	return NewSuccessResponse(fmt.Sprintf("Successfully deleted %s '%s'", cmd.Resource, cmd.Name), nil)
+	if !cmd.DryRun {
+		return NewSuccessResponse(fmt.Sprintf("Successfully deleted %s '%s'", cmd.Resource, cmd.Name), nil)
+	}
+	if !protected {
+		return NewSuccessResponse(fmt.Sprintf("Dry run: %s '%s' would be deleted", cmd.Resource, cmd.Name), nil)
+	}
+
+	expires := time.Now().Add(confirmationTTL).Truncate(time.Second)
+	return NewSuccessResponse(
+		fmt.Sprintf("Dry run: %s '%s' would be deleted; it is protected, so pass the confirmation token to delete it", cmd.Resource, cmd.Name),
+		DeleteConfirmation{ConfirmationToken: h.confirmationToken(attrs, expires), ExpiresAt: expires},
+	)
 }
 
-// handleLogsCommand handles the 'logs' command
-func (h *Handler) handleLogsCommand(cmd *Command) (*Response, error) {
-	if cmd.Namespace == "" || cmd.LogOptions == nil || cmd.LogOptions.Pod == "" {
-		return NewErrorResponse(fmt.Errorf("namespace and pod are required"))
+// handleWatchCommand handles the 'watch' command. Events are streamed
+// through the event sink attached to the context as they happen, and
+// collected into the response once the watch ends after the timeout or the
+// requested number of events.
+func (h *Handler) handleWatchCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if cmd.Resource == "" {
+		return NewErrorResponse(fmt.Errorf("resource type is required"))
 	}
 
-	opts := logs.LogOptions{
-		Namespace: cmd.Namespace,
-		Pod:       cmd.LogOptions.Pod,
-		Container: cmd.LogOptions.Container,
+	if cmd.TimeoutSeconds < 0 || cmd.MaxEvents < 0 {
+		return NewErrorResponse(fmt.Errorf("timeout_seconds and max_events must not be negative"))
 	}
 
-	// Parse 'since' parameter if provided
-	if cmd.LogOptions.Since != "" {
-		if since, err := time.ParseDuration(cmd.LogOptions.Since); err == nil {
-			sinceTime := time.Now().Add(-since)
-			opts.SinceTime = &sinceTime
+	timeout := defaultWatchTimeout
+	if cmd.TimeoutSeconds > 0 {
+		timeout = time.Duration(cmd.TimeoutSeconds) * time.Second
+	}
+	if timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector:   cmd.LabelSelector,
+		FieldSelector:   cmd.FieldSelector,
+		ResourceVersion: cmd.ResourceVersion,
+	}
+
+	// Watching a single object is a watch filtered by name
+	if cmd.Name != "" {
+		nameSelector := fields.OneTermEqualSelector("metadata.name", cmd.Name).String()
+		if opts.FieldSelector != "" {
+			opts.FieldSelector += "," + nameSelector
 		} else {
-			// Try parsing as a timestamp
-			if sinceTime, err := time.Parse(time.RFC3339, cmd.LogOptions.Since); err == nil {
-				opts.SinceTime = &sinceTime
-			} else {
-				return NewErrorResponse(fmt.Errorf("invalid 'since' parameter: %v", err))
-			}
+			opts.FieldSelector = nameSelector
 		}
 	}
 
-	// Parse 'tail' parameter if provided
-	if cmd.LogOptions.Tail > 0 {
-		tail := int64(cmd.LogOptions.Tail)
-		opts.Tail = &tail
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := WatchResult{Events: []kubernetes.WatchEvent{}}
+	err := h.k8sClient.WatchResources(ctx, cmd.Resource, cmd.Namespace, opts, func(event kubernetes.WatchEvent) error {
+		result.Count++
+		if len(result.Events) < maxWatchResultEvents {
+			result.Events = append(result.Events, event)
+		} else {
+			result.Truncated = true
+		}
+		if rv := event.Object.GetResourceVersion(); rv != "" {
+			result.ResourceVersion = rv
+		}
+
+		if err := emitEvent(ctx, event.Type, event); err != nil {
+			return err
+		}
+		if cmd.MaxEvents > 0 && result.Count >= cmd.MaxEvents {
+			return errWatchComplete
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWatchComplete) {
+		return NewErrorResponse(err)
+	}
+
+	return NewSuccessResponse(fmt.Sprintf("Observed %d events on %s", result.Count, cmd.Resource), result)
+}
+
+// handleLogsCommand handles the 'logs' command
+func (h *Handler) handleLogsCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if err := validateLogSource(cmd); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	opts, err := logOptions(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	if cmd.LogOptions.Cluster {
+		return h.summarizeLogs(ctx, cmd, opts)
+	}
+
+	if cmd.LogOptions.Follow {
+		return h.followLogs(ctx, cmd, opts)
+	}
+
+	logEntries, warnings, err := h.collectLogs(ctx, cmd, opts)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	return newLogResponse(fmt.Sprintf("Successfully retrieved logs from %s", logSource(cmd)), logEntries, warnings)
+}
+
+// followLogs streams new log entries through the event sink attached to the
+// context until the maximum duration elapses or the client goes away. The
+// most recent entries are also collected into the response.
+func (h *Handler) followLogs(ctx context.Context, cmd *Command, opts logs.LogOptions) (*Response, error) {
+	duration := defaultFollowDuration
+	if cmd.LogOptions.MaxDuration != "" {
+		d, err := time.ParseDuration(cmd.LogOptions.MaxDuration)
+		if err != nil || d <= 0 {
+			return NewErrorResponse(fmt.Errorf("invalid 'max_duration' parameter: %s", cmd.LogOptions.MaxDuration))
+		}
+		duration = d
+	}
+	if duration > maxFollowDuration {
+		duration = maxFollowDuration
+	}
+
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	if len(targets) > maxStreamedPods {
+		return NewErrorResponse(fmt.Errorf("%s selects %d pods; at most %d can be followed at once", logSource(cmd), len(targets), maxStreamedPods))
 	}
 
-	logEntries, err := h.logManager.GetLogs(opts)
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	opts.Follow = true
+	logEntries := []logs.LogEntry{}
+	count := 0
+	failures, err := h.logManager.StreamPodLogs(ctx, opts, targets, func(entry logs.LogEntry) error {
+		count++
+		logEntries = append(logEntries, entry)
+		if len(logEntries) > 2*maxFollowResultEntries {
+			logEntries = append([]logs.LogEntry(nil), logEntries[len(logEntries)-maxFollowResultEntries:]...)
+		}
+		return emitEvent(ctx, "log", entry)
+	})
+	if err == nil {
+		err = allFailed(targets, failures)
+	}
 	if err != nil {
 		return NewErrorResponse(err)
 	}
 
-	return NewSuccessResponse(fmt.Sprintf("Successfully retrieved logs from pod '%s'", cmd.LogOptions.Pod), logEntries)
+	if len(logEntries) > maxFollowResultEntries {
+		logEntries = logEntries[len(logEntries)-maxFollowResultEntries:]
+	}
+
+	return newLogResponse(
+		fmt.Sprintf("Followed logs from %s: %d entries, returning the last %d", logSource(cmd), count, len(logEntries)),
+		logEntries,
+		podWarnings(failures),
+	)
 }
 
 // handleSearchLogsCommand handles the 'search_logs' command
-func (h *Handler) handleSearchLogsCommand(cmd *Command) (*Response, error) {
-	if cmd.Namespace == "" || cmd.LogOptions == nil || cmd.LogOptions.Pod == "" {
-		return NewErrorResponse(fmt.Errorf("namespace and pod are required"))
+func (h *Handler) handleSearchLogsCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if err := validateLogSource(cmd); err != nil {
+		return NewErrorResponse(err)
 	}
 
-	if cmd.LogOptions.Pattern == "" {
-		return NewErrorResponse(fmt.Errorf("search pattern is required"))
+	if cmd.LogOptions.Pattern == "" && cmd.LogOptions.Query == "" {
+		return NewErrorResponse(fmt.Errorf("search pattern or query is required"))
 	}
 
-	opts := logs.LogOptions{
-		Namespace: cmd.Namespace,
-		Pod:       cmd.LogOptions.Pod,
-		Container: cmd.LogOptions.Container,
-		Pattern:   cmd.LogOptions.Pattern,
-		LogLevel:  cmd.LogOptions.LogLevel,
+	opts, err := logOptions(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
 	}
 
-	// Parse 'since' parameter if provided
-	if cmd.LogOptions.Since != "" {
-		if since, err := time.ParseDuration(cmd.LogOptions.Since); err == nil {
-			sinceTime := time.Now().Add(-since)
-			opts.SinceTime = &sinceTime
-		} else {
-			// Try parsing as a timestamp
-			if sinceTime, err := time.Parse(time.RFC3339, cmd.LogOptions.Since); err == nil {
-				opts.SinceTime = &sinceTime
-			} else {
-				return NewErrorResponse(fmt.Errorf("invalid 'since' parameter: %v", err))
-			}
+	if cmd.LogOptions.Cluster {
+		return h.summarizeLogs(ctx, cmd, opts)
+	}
+
+	before, after, err := contextLines(cmd.LogOptions)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+	if before > 0 || after > 0 {
+		return h.searchLogHunks(ctx, cmd, opts, before, after)
+	}
+
+	logEntries, warnings, err := h.collectLogs(ctx, cmd, opts)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	return newLogResponse(fmt.Sprintf("Successfully searched logs from %s", logSource(cmd)), logEntries, warnings)
+}
+
+// searchLogHunks searches logs and returns every match with its
+// surrounding entries, grouped into hunks
+func (h *Handler) searchLogHunks(ctx context.Context, cmd *Command, opts logs.LogOptions, before, after int) (*Response, error) {
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	hunks, failures := h.logManager.GetPodHunks(ctx, opts, targets, logs.DefaultConcurrency, before, after)
+	if err := allFailed(targets, failures); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	matches := 0
+	for _, hunk := range hunks {
+		matches += len(hunk.Matches)
+	}
+
+	return newLogResponse(
+		fmt.Sprintf("Found %d matches in %d hunks in logs from %s", matches, len(hunks), logSource(cmd)),
+		hunks,
+		podWarnings(failures),
+	)
+}
+
+// contextLines returns the number of entries to show before and after
+// each search match
+func contextLines(opts *LogOptions) (int, int, error) {
+	before, after := opts.Before, opts.After
+	if opts.Context != 0 {
+		if before == 0 {
+			before = opts.Context
+		}
+		if after == 0 {
+			after = opts.Context
 		}
 	}
 
-	// Parse 'tail' parameter if provided
-	if cmd.LogOptions.Tail > 0 {
-		tail := int64(cmd.LogOptions.Tail)
-		opts.Tail = &tail
+	if before < 0 || after < 0 || opts.Context < 0 {
+		return 0, 0, fmt.Errorf("context lines cannot be negative")
 	}
+	if before > maxContextLines || after > maxContextLines {
+		return 0, 0, fmt.Errorf("at most %d context lines are supported", maxContextLines)
+	}
+	return before, after, nil
+}
 
-	logEntries, err := h.logManager.GetLogs(opts)
+// handleExportLogsCommand handles the 'export_logs' command, returning the
+// export as a string
+func (h *Handler) handleExportLogsCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	var buf bytes.Buffer
+	_, warnings, err := h.exportLogs(ctx, cmd, &buf)
 	if err != nil {
 		return NewErrorResponse(err)
 	}
 
-	return NewSuccessResponse(fmt.Sprintf("Successfully searched logs from pod '%s'", cmd.LogOptions.Pod), logEntries)
+	// Create a response with the exported logs as a string
+	return newLogResponse(
+		fmt.Sprintf("Successfully exported logs from %s in %s format", logSource(cmd), cmd.LogOptions.Format),
+		map[string]string{"exported_logs": buf.String()},
+		warnings,
+	)
+}
+
+// ExportLogs runs an 'export_logs' command, writing the export to w as the
+// entries are read instead of returning it in the response. The entries of
+// several pods are merged in timestamp order as they arrive, so memory use
+// does not grow with the size of the logs. Nothing is written to w when the
+// command fails before its first entry.
+func (h *Handler) ExportLogs(ctx context.Context, cmd *Command, w io.Writer) (*Response, error) {
+	return countRedacted(ctx, func(ctx context.Context) (*Response, error) {
+		if err := h.authorize(ctx, cmd); err != nil {
+			return NewErrorResponse(err)
+		}
+
+		count, warnings, err := h.exportLogs(ctx, cmd, w)
+		if err != nil {
+			return NewErrorResponse(err)
+		}
+
+		return newLogResponse(
+			fmt.Sprintf("Exported %d entries from %s in %s format", count, logSource(cmd), cmd.LogOptions.Format),
+			nil,
+			warnings,
+		)
+	})
 }
 
-// handleExportLogsCommand handles the 'export_logs' command
-func (h *Handler) handleExportLogsCommand(cmd *Command) (*Response, error) {
-	if cmd.Namespace == "" || cmd.LogOptions == nil || cmd.LogOptions.Pod == "" {
-		return NewErrorResponse(fmt.Errorf("namespace and pod are required"))
+// exportLogs writes the logs selected by an export command to w and returns
+// the number of entries written and warnings about pods that could not be
+// read
+func (h *Handler) exportLogs(ctx context.Context, cmd *Command, w io.Writer) (int, []string, error) {
+	if err := validateLogSource(cmd); err != nil {
+		return 0, nil, err
 	}
 
 	if cmd.LogOptions.Format == "" {
-		return NewErrorResponse(fmt.Errorf("export format is required"))
+		return 0, nil, fmt.Errorf("export format is required")
+	}
+	format, err := logs.GetExportFormat(cmd.LogOptions.Format)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	opts, err := logOptions(cmd)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(targets) > maxStreamedPods {
+		return 0, nil, fmt.Errorf("%s selects %d pods; at most %d can be exported at once", logSource(cmd), len(targets), maxStreamedPods)
+	}
+
+	entryWriter := format.NewWriter(w)
+	count := 0
+	failures, err := h.logManager.MergePodLogs(ctx, opts, targets, func(entry logs.LogEntry) error {
+		count++
+		return entryWriter.Write(entry)
+	})
+	if err == nil {
+		err = allFailed(targets, failures)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := entryWriter.Close(); err != nil {
+		return 0, nil, err
+	}
+	return count, podWarnings(failures), nil
+}
+
+// handleLogStatsCommand handles the 'log_stats' command
+func (h *Handler) handleLogStatsCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if err := validateLogSource(cmd); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	width := logs.DefaultBucketWidth
+	if cmd.LogOptions.Bucket != "" {
+		d, err := time.ParseDuration(cmd.LogOptions.Bucket)
+		if err != nil || d < time.Second {
+			return NewErrorResponse(fmt.Errorf("invalid 'bucket' parameter: %s; expected a duration of at least 1s", cmd.LogOptions.Bucket))
+		}
+		width = d
+	}
+
+	opts, err := logOptions(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	stats, failures := h.logManager.GetPodStats(ctx, opts, targets, logs.DefaultConcurrency, width)
+	if err := allFailed(targets, failures); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	return newLogResponse(
+		fmt.Sprintf("Computed statistics over %d entries from %s", stats.Total, logSource(cmd)),
+		stats,
+		podWarnings(failures),
+	)
+}
+
+// handleSummarizeLogsCommand handles the 'summarize_logs' command
+func (h *Handler) handleSummarizeLogsCommand(ctx context.Context, cmd *Command) (*Response, error) {
+	if err := validateLogSource(cmd); err != nil {
+		return NewErrorResponse(err)
 	}
 
+	opts, err := logOptions(cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	return h.summarizeLogs(ctx, cmd, opts)
+}
+
+// summarizeLogs groups the filtered log entries into templates of similar
+// lines
+func (h *Handler) summarizeLogs(ctx context.Context, cmd *Command, opts logs.LogOptions) (*Response, error) {
+	maxTemplates := cmd.LogOptions.MaxTemplates
+	if maxTemplates < 0 {
+		return NewErrorResponse(fmt.Errorf("invalid 'max_templates' parameter: %d", maxTemplates))
+	}
+	if maxTemplates == 0 {
+		maxTemplates = logs.DefaultMaxTemplates
+	}
+
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return NewErrorResponse(err)
+	}
+
+	summary, failures := h.logManager.GetPodTemplates(ctx, opts, targets, logs.DefaultConcurrency, maxTemplates)
+	if err := allFailed(targets, failures); err != nil {
+		return NewErrorResponse(err)
+	}
+
+	return newLogResponse(
+		fmt.Sprintf("Summarized %d entries from %s into %d templates", summary.Entries, logSource(cmd), summary.TemplateCount),
+		summary,
+		podWarnings(failures),
+	)
+}
+
+// liveObject returns the current version of the object a dry-run update or
+// apply would replace, or nil when it does not exist yet
+func (h *Handler) liveObject(ctx context.Context, resource, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if obj.GetName() == "" {
+		return nil, nil
+	}
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+
+	live, err := h.k8sClient.GetResource(ctx, resource, namespace, obj.GetName())
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return live, err
+}
+
+// dryRunResponse describes the outcome of a dry-run write: the object as
+// it would have been persisted and, when it would replace a live object,
+// the fields that would change. The diff is computed on the unredacted
+// objects, so changes to masked values are listed with masked values; obj
+// is redacted afterwards.
+func (h *Handler) dryRunResponse(ctx context.Context, resource, action string, live, obj *unstructured.Unstructured) (*Response, error) {
+	result := DryRunResult{Object: obj}
+	if live == nil {
+		h.k8sClient.RedactObject(ctx, obj)
+		return NewSuccessResponse(fmt.Sprintf("Dry run: %s '%s' would be created", resource, obj.GetName()), result)
+	}
+
+	result.Diff = h.k8sClient.DiffObjects(ctx, live, obj)
+	h.k8sClient.RedactObject(ctx, obj)
+	return NewSuccessResponse(fmt.Sprintf("Dry run: %s '%s' would be %s, changing %d fields", resource, obj.GetName(), action, len(result.Diff)), result)
+}
+
+// validateLogSource checks that a log command names a namespace and the
+// pods to read from
+func validateLogSource(cmd *Command) error {
+	if cmd.Namespace == "" || cmd.LogOptions == nil ||
+		(cmd.LogOptions.Pod == "" && cmd.LogOptions.Selector == "" && cmd.LogOptions.Workload == "") {
+		return fmt.Errorf("namespace and one of pod, selector or workload are required")
+	}
+	if cmd.LogOptions.Pod != "" && (cmd.LogOptions.Selector != "" || cmd.LogOptions.Workload != "") {
+		return fmt.Errorf("pod cannot be combined with selector or workload")
+	}
+	if cmd.LogOptions.Container != "" && cmd.LogOptions.AllContainers {
+		return fmt.Errorf("container cannot be combined with all_containers")
+	}
+	return nil
+}
+
+// logSource describes the pods a log command reads from
+func logSource(cmd *Command) string {
+	switch {
+	case cmd.LogOptions.Pod != "":
+		return fmt.Sprintf("pod '%s'", cmd.LogOptions.Pod)
+	case cmd.LogOptions.Workload != "" && cmd.LogOptions.Selector != "":
+		return fmt.Sprintf("pods of '%s' matching '%s'", cmd.LogOptions.Workload, cmd.LogOptions.Selector)
+	case cmd.LogOptions.Workload != "":
+		return fmt.Sprintf("pods of '%s'", cmd.LogOptions.Workload)
+	default:
+		return fmt.Sprintf("pods matching '%s'", cmd.LogOptions.Selector)
+	}
+}
+
+// logTargets resolves the pods a log command reads from
+func (h *Handler) logTargets(ctx context.Context, cmd *Command) ([]logs.PodTarget, error) {
+	if cmd.LogOptions.Pod != "" {
+		return h.logManager.ResolvePod(ctx, cmd.Namespace, cmd.LogOptions.Pod, cmd.LogOptions.Container, cmd.LogOptions.AllContainers)
+	}
+
+	targets, err := h.logManager.ResolvePods(ctx, cmd.Namespace, cmd.LogOptions.Selector, cmd.LogOptions.Workload, cmd.LogOptions.Container, cmd.LogOptions.AllContainers)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no pods found for %s in namespace '%s'", logSource(cmd), cmd.Namespace)
+	}
+	return targets, nil
+}
+
+// collectLogs retrieves the logs of every pod selected by a command, merged
+// in timestamp order. Pods that fail are returned as warnings unless every
+// pod failed.
+func (h *Handler) collectLogs(ctx context.Context, cmd *Command, opts logs.LogOptions) ([]logs.LogEntry, []string, error) {
+	targets, err := h.logTargets(ctx, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logEntries, failures := h.logManager.GetPodLogs(ctx, opts, targets, logs.DefaultConcurrency)
+	if err := allFailed(targets, failures); err != nil {
+		return nil, nil, err
+	}
+	if logEntries == nil {
+		logEntries = []logs.LogEntry{}
+	}
+
+	return logEntries, podWarnings(failures), nil
+}
+
+// allFailed returns an error when no pod's logs could be read
+func allFailed(targets []logs.PodTarget, failures []*logs.PodError) error {
+	if len(failures) == 0 || len(failures) < len(targets) {
+		return nil
+	}
+	if len(targets) == 1 {
+		// A single pod's error is returned as is, keeping its status code
+		return failures[0].Err
+	}
+	return fmt.Errorf("failed to get logs from all %d pods: %w", len(targets), failures[0])
+}
+
+// podWarnings formats per-pod failures as response warnings
+func podWarnings(failures []*logs.PodError) []string {
+	var warnings []string
+	for _, failure := range failures {
+		warnings = append(warnings, failure.Error())
+	}
+	return warnings
+}
+
+// newLogResponse creates a success response carrying per-pod warnings
+func newLogResponse(message string, data interface{}, warnings []string) (*Response, error) {
+	resp, err := NewSuccessResponse(message, data)
+	if err != nil {
+		return nil, err
+	}
+	resp.Warnings = warnings
+	return resp, nil
+}
+
+// logOptions converts the log options of a command into options for the
+// log manager
+func logOptions(cmd *Command) (logs.LogOptions, error) {
 	opts := logs.LogOptions{
 		Namespace: cmd.Namespace,
 		Pod:       cmd.LogOptions.Pod,
 		Container: cmd.LogOptions.Container,
 		Pattern:   cmd.LogOptions.Pattern,
 		LogLevel:  cmd.LogOptions.LogLevel,
+		Parser:    cmd.LogOptions.Parser,
+		Previous:  cmd.LogOptions.Previous,
+
+		Multiline:      cmd.LogOptions.Multiline,
+		MultilineStart: cmd.LogOptions.MultilineStart,
 	}
 
 	// Parse 'since' parameter if provided
@@ -224,7 +971,7 @@ func (h *Handler) handleExportLogsCommand(cmd *Command) (*Response, error) {
 			if sinceTime, err := time.Parse(time.RFC3339, cmd.LogOptions.Since); err == nil {
 				opts.SinceTime = &sinceTime
 			} else {
-				return NewErrorResponse(fmt.Errorf("invalid 'since' parameter: %v", err))
+				return opts, fmt.Errorf("invalid 'since' parameter: %v", err)
 			}
 		}
 	}
@@ -235,19 +982,65 @@ func (h *Handler) handleExportLogsCommand(cmd *Command) (*Response, error) {
 		opts.Tail = &tail
 	}
 
-	logEntries, err := h.logManager.GetLogs(opts)
-	if err != nil {
-		return NewErrorResponse(err)
+	// Compile 'query' parameter if provided
+	if cmd.LogOptions.Query != "" {
+		query, err := logs.ParseQuery(cmd.LogOptions.Query)
+		if err != nil {
+			return opts, err
+		}
+		opts.Query = query
 	}
 
-	var buf bytes.Buffer
-	if err := h.logManager.ExportLogs(logEntries, cmd.LogOptions.Format, &buf); err != nil {
-		return NewErrorResponse(err)
+	return opts, nil
+}
+
+// decodeObject decodes the manifest carried by a command. A name given on
+// the command is used when the manifest has none and must match otherwise.
+func decodeObject(cmd *Command) (*unstructured.Unstructured, error) {
+	if err := checkMasked(cmd); err != nil {
+		return nil, err
 	}
 
-	// Create a response with the exported logs as a string
-	return NewSuccessResponse(
-		fmt.Sprintf("Successfully exported logs from pod '%s' in %s format", cmd.LogOptions.Pod, cmd.LogOptions.Format),
-		map[string]string{"exported_logs": buf.String()},
-	)
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(cmd.Data, &obj.Object); err != nil {
+		return nil, fmt.Errorf("invalid resource data: %v", err)
+	}
+
+	if cmd.Name != "" {
+		if obj.GetName() == "" {
+			obj.SetName(cmd.Name)
+		} else if obj.GetName() != cmd.Name {
+			return nil, fmt.Errorf("name '%s' does not match the name '%s' in the resource data", cmd.Name, obj.GetName())
+		}
+	}
+
+	return &obj, nil
+}
+
+// redactedObjectsWarning is added to responses carrying redacted objects
+const redactedObjectsWarning = "some values in this response are redacted; redacted objects cannot be written back with update or apply, as the masked values would replace the real ones: patch only the fields to change instead"
+
+// MaskedValueError rejects writes carrying values masked by redaction,
+// which would otherwise replace the real values in the cluster
+type MaskedValueError struct {
+	Command CommandType `json:"command"`
+}
+
+// Error implements the error interface
+func (e *MaskedValueError) Error() string {
+	return fmt.Sprintf("the %s data contains values masked by redaction (%s or [REDACTED:...]); redacted objects cannot be written back, as the masked values would replace the real ones: send only the fields to change, e.g. with a merge patch, or their real values", e.Command, redact.SecretMask)
+}
+
+// StatusCode returns the HTTP status code for the error
+func (e *MaskedValueError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// checkMasked returns a MaskedValueError when the data of a write contains
+// values masked by redaction
+func checkMasked(cmd *Command) error {
+	if bytes.Contains(cmd.Data, []byte(redact.SecretMask)) || bytes.Contains(cmd.Data, []byte(redact.MaskPrefix)) {
+		return &MaskedValueError{Command: cmd.Type}
+	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONRPCVersion is the only JSON-RPC version spoken by MCP
+const JSONRPCVersion = "2.0"
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	ErrCodeParseError     = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternalError  = -32603
+
+	// ErrCodeServerNotInitialized is returned when a request arrives before
+	// the initialize handshake has completed
+	ErrCodeServerNotInitialized = -32002
+)
+
+// Message is a raw JSON-RPC 2.0 message as received from a client. It can
+// be a request (method and id), a notification (method, no id) or a
+// response (id and result or error).
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// IsNotification reports whether the message is a notification
+func (m *Message) IsNotification() bool {
+	return m.Method != "" && len(m.ID) == 0
+}
+
+// IsRequest reports whether the message is a request that expects a response
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && len(m.ID) != 0
+}
+
+// RPCResponse represents a JSON-RPC 2.0 response
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError represents a JSON-RPC 2.0 error object
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error implements the error interface
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// Notification represents a JSON-RPC 2.0 notification sent by the server
+type Notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// NewNotification creates a new server notification
+func NewNotification(method string, params interface{}) *Notification {
+	return &Notification{
+		JSONRPC: JSONRPCVersion,
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// newResultResponse creates a successful JSON-RPC response
+func newResultResponse(id json.RawMessage, result interface{}) *RPCResponse {
+	return &RPCResponse{
+		JSONRPC: JSONRPCVersion,
+		ID:      id,
+		Result:  result,
+	}
+}
+
+// newErrorRPCResponse creates a failed JSON-RPC response
+func newErrorRPCResponse(id json.RawMessage, code int, message string) *RPCResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &RPCResponse{
+		JSONRPC: JSONRPCVersion,
+		ID:      id,
+		Error:   &RPCError{Code: code, Message: message},
+	}
+}
+
+// ParseMessage parses a JSON-RPC 2.0 message
+func ParseMessage(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to parse message: %v", err)
+	}
+	return &msg, nil
+}
+
+// IsJSONRPC reports whether a payload looks like a JSON-RPC message rather
+// than a legacy Command
+func IsJSONRPC(data []byte) bool {
+	var probe struct {
+		JSONRPC *string `json:"jsonrpc"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(data), &probe); err != nil {
+		return false
+	}
+	return probe.JSONRPC != nil
+}
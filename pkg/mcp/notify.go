@@ -0,0 +1,153 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// Server-initiated notification methods
+const (
+	MethodProgress   = "notifications/progress"
+	MethodLogMessage = "notifications/message"
+)
+
+// Log levels of notifications/message, in increasing order of severity
+var logLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// logLevelRank returns the severity rank of an MCP log level, or -1
+func logLevelRank(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// Notifier delivers server-initiated JSON-RPC notifications to a client.
+// Transports provide an implementation bound to the stream the client is
+// listening on.
+type Notifier interface {
+	Notify(notification *Notification) error
+}
+
+// ProgressParams are sent with notifications/progress
+type ProgressParams struct {
+	ProgressToken json.RawMessage `json:"progressToken"`
+	Progress      float64         `json:"progress"`
+	Total         float64         `json:"total,omitempty"`
+	Message       string          `json:"message,omitempty"`
+}
+
+// LogMessageParams are sent with notifications/message
+type LogMessageParams struct {
+	Level  string      `json:"level"`
+	Logger string      `json:"logger,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+// EventSink receives the intermediate events streamed by long-running
+// commands such as watch. Transports attach a sink to the command's context
+// to forward events as they happen; the command's final response still
+// carries its result.
+type EventSink interface {
+	Event(event string, data interface{}) error
+}
+
+type notifierKey struct{}
+type progressTokenKey struct{}
+type eventSinkKey struct{}
+type logLevelKey struct{}
+
+// WithNotifier returns a context that delivers notifications to n
+func WithNotifier(ctx context.Context, n Notifier) context.Context {
+	return context.WithValue(ctx, notifierKey{}, n)
+}
+
+// notifierFromContext returns the notifier attached to ctx, if any
+func notifierFromContext(ctx context.Context) Notifier {
+	n, _ := ctx.Value(notifierKey{}).(Notifier)
+	return n
+}
+
+// withProgressToken returns a context carrying the client's progress token
+func withProgressToken(ctx context.Context, token json.RawMessage) context.Context {
+	return context.WithValue(ctx, progressTokenKey{}, token)
+}
+
+// notify sends a notification through the notifier attached to ctx. It is
+// a no-op when the transport cannot deliver server-initiated messages.
+func notify(ctx context.Context, method string, params interface{}) {
+	n := notifierFromContext(ctx)
+	if n == nil {
+		return
+	}
+	if err := n.Notify(NewNotification(method, params)); err != nil {
+		log.Printf("Failed to send %s notification: %v", method, err)
+	}
+}
+
+// reportProgress sends a notifications/progress message if the client asked
+// for progress on the current request. Progress must increase with each
+// call; total may be zero when unknown.
+func reportProgress(ctx context.Context, progress, total float64, message string) {
+	token, _ := ctx.Value(progressTokenKey{}).(json.RawMessage)
+	if len(token) == 0 {
+		return
+	}
+	notify(ctx, MethodProgress, ProgressParams{
+		ProgressToken: token,
+		Progress:      progress,
+		Total:         total,
+		Message:       message,
+	})
+}
+
+// WithEventSink returns a context that streams command events to sink
+func WithEventSink(ctx context.Context, sink EventSink) context.Context {
+	return context.WithValue(ctx, eventSinkKey{}, sink)
+}
+
+// emitEvent streams an event to the sink attached to ctx. An error means the
+// receiver has gone away and the command should stop.
+func emitEvent(ctx context.Context, event string, data interface{}) error {
+	sink, _ := ctx.Value(eventSinkKey{}).(EventSink)
+	if sink == nil {
+		return nil
+	}
+	return sink.Event(event, data)
+}
+
+// withLogLevel returns a context carrying the minimum log level requested
+// by the client
+func withLogLevel(ctx context.Context, level string) context.Context {
+	return context.WithValue(ctx, logLevelKey{}, level)
+}
+
+// notificationSink forwards command events to an MCP client as log message
+// notifications, and as progress notifications when the client supplied a
+// progress token
+type notificationSink struct {
+	ctx    context.Context
+	logger string
+	count  float64
+}
+
+// Event implements EventSink
+func (s *notificationSink) Event(event string, data interface{}) error {
+	s.count++
+	reportProgress(s.ctx, s.count, 0, event)
+
+	if minLevel, _ := s.ctx.Value(logLevelKey{}).(string); logLevelRank(minLevel) > logLevelRank("info") {
+		return nil
+	}
+	notify(s.ctx, MethodLogMessage, LogMessageParams{
+		Level:  "info",
+		Logger: s.logger,
+		Data:   data,
+	})
+
+	// Stop the command if the client went away
+	return s.ctx.Err()
+}
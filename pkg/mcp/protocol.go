@@ -2,7 +2,10 @@ package mcp
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 // CommandType represents the type of MCP command
@@ -13,14 +16,43 @@ const (
 	ListCommand   CommandType = "list"
 	GetCommand    CommandType = "get"
 	CreateCommand CommandType = "create"
+	UpdateCommand CommandType = "update"
+	PatchCommand  CommandType = "patch"
+	ApplyCommand  CommandType = "apply"
 	DeleteCommand CommandType = "delete"
+	WatchCommand  CommandType = "watch"
 
 	// Log operations
-	LogsCommand       CommandType = "logs"
-	SearchLogsCommand CommandType = "search_logs"
-	ExportLogsCommand CommandType = "export_logs"
+	LogsCommand          CommandType = "logs"
+	SearchLogsCommand    CommandType = "search_logs"
+	ExportLogsCommand    CommandType = "export_logs"
+	LogStatsCommand      CommandType = "log_stats"
+	SummarizeLogsCommand CommandType = "summarize_logs"
+
+	// Authorization
+	CanICommand CommandType = "can_i"
 )
 
+// readOnlyCommands are the commands that never modify the cluster. Every
+// other command is considered mutating, so commands added later are
+// rejected in read-only mode until listed here.
+var readOnlyCommands = map[CommandType]bool{
+	ListCommand:          true,
+	GetCommand:           true,
+	WatchCommand:         true,
+	LogsCommand:          true,
+	SearchLogsCommand:    true,
+	ExportLogsCommand:    true,
+	LogStatsCommand:      true,
+	SummarizeLogsCommand: true,
+	CanICommand:          true,
+}
+
+// Mutating reports whether commands of this type may modify the cluster
+func (t CommandType) Mutating() bool {
+	return !readOnlyCommands[t]
+}
+
 // Command represents an MCP command
 type Command struct {
 	Type       CommandType     `json:"type"`
@@ -29,6 +61,33 @@ type Command struct {
 	Namespace  string          `json:"namespace,omitempty"`
 	Data       json.RawMessage `json:"data,omitempty"`
 	LogOptions *LogOptions     `json:"log_options,omitempty"`
+
+	// Options for list
+	LabelSelector string `json:"label_selector,omitempty"`
+	FieldSelector string `json:"field_selector,omitempty"`
+	Limit         int64  `json:"limit,omitempty"`
+	Continue      string `json:"continue,omitempty"`
+
+	// Options for watch; the list selectors apply as well
+	ResourceVersion string `json:"resource_version,omitempty"`
+	TimeoutSeconds  int64  `json:"timeout_seconds,omitempty"`
+	MaxEvents       int    `json:"max_events,omitempty"`
+
+	// Options for patch and apply
+	PatchType    string `json:"patch_type,omitempty"`
+	FieldManager string `json:"field_manager,omitempty"`
+	Force        bool   `json:"force,omitempty"`
+
+	// DryRun makes create, update, patch, apply and delete run every
+	// stage of the request without persisting it. Dry-run deletes of
+	// protected objects return the ConfirmationToken that the actual
+	// delete must carry.
+	DryRun            bool   `json:"dry_run,omitempty"`
+	ConfirmationToken string `json:"confirmation_token,omitempty"`
+
+	// Verb is the command type a can_i command asks about; the other
+	// fields describe the command as they would for that type
+	Verb CommandType `json:"verb,omitempty"`
 }
 
 // LogOptions represents options for log commands
@@ -40,6 +99,51 @@ type LogOptions struct {
 	Pattern   string `json:"pattern,omitempty"`
 	LogLevel  string `json:"log_level,omitempty"`
 	Format    string `json:"format,omitempty"`
+	// Parser selects how lines are structured: auto (the default), json,
+	// logfmt, klog, common or plain
+	Parser string `json:"parser,omitempty"`
+	// Query filters entries on their parsed fields, e.g.
+	// level>=warn AND status=500; see logs.Query
+	Query string `json:"query,omitempty"`
+	// Multiline groups multi-line events such as stack traces: auto (the
+	// default) or none. MultilineStart is a regular expression matching
+	// the first line of every event instead.
+	Multiline      string `json:"multiline,omitempty"`
+	MultilineStart string `json:"multiline_start,omitempty"`
+
+	// Cluster makes logs and search_logs return templates of similar
+	// lines, as summarize_logs does, instead of the entries. MaxTemplates
+	// bounds the templates returned.
+	Cluster      bool `json:"cluster,omitempty"`
+	MaxTemplates int  `json:"max_templates,omitempty"`
+
+	// Bucket is the width of log_stats histogram buckets as a Go duration,
+	// e.g. 1m or 5m
+	Bucket string `json:"bucket,omitempty"`
+
+	// Before, After and Context make search_logs return each match with
+	// the entries around it, grouped into hunks. Context sets both Before
+	// and After unless they are given.
+	Before  int `json:"before,omitempty"`
+	After   int `json:"after,omitempty"`
+	Context int `json:"context,omitempty"`
+
+	// Selector and Workload select several pods instead of a single Pod:
+	// the pods matching a label selector and/or the pods of a workload
+	// given as kind/name, e.g. deployment/web
+	Selector string `json:"selector,omitempty"`
+	Workload string `json:"workload,omitempty"`
+
+	// Previous reads the logs of the previous instance of the containers,
+	// e.g. before a crash. AllContainers reads every init, regular and
+	// ephemeral container of the pods instead of Container.
+	Previous      bool `json:"previous,omitempty"`
+	AllContainers bool `json:"all_containers,omitempty"`
+
+	// Follow streams new entries as they are written, for at most
+	// MaxDuration (a Go duration such as 30s or 5m)
+	Follow      bool   `json:"follow,omitempty"`
+	MaxDuration string `json:"max_duration,omitempty"`
 }
 
 // Response represents an MCP response
@@ -48,6 +152,25 @@ type Response struct {
 	Message string          `json:"message,omitempty"`
 	Data    json.RawMessage `json:"data,omitempty"`
 	Error   string          `json:"error,omitempty"`
+	// Code is an HTTP status code classifying a failure, when known
+	Code int `json:"code,omitempty"`
+	// Details carries structured information about a failure
+	Details interface{} `json:"details,omitempty"`
+	// Page describes how to fetch the rest of a paginated list
+	Page *PageInfo `json:"page,omitempty"`
+	// Warnings reports partial failures that did not fail the command,
+	// such as pods whose logs could not be read
+	Warnings []string `json:"warnings,omitempty"`
+	// Redacted is the number of sensitive values masked in the response
+	Redacted int `json:"redacted,omitempty"`
+}
+
+// PageInfo describes the remainder of a paginated list
+type PageInfo struct {
+	// Continue is passed back as the continue option to fetch the next page
+	Continue string `json:"continue"`
+	// RemainingItemCount is the number of items after this page, if known
+	RemainingItemCount *int64 `json:"remaining_item_count,omitempty"`
 }
 
 // NewSuccessResponse creates a new success response
@@ -69,12 +192,37 @@ func NewSuccessResponse(message string, data interface{}) (*Response, error) {
 	}, nil
 }
 
-// NewErrorResponse creates a new error response
+// NewErrorResponse creates a new error response. Errors that know their
+// status code or carry structured details have them copied to the response.
 func NewErrorResponse(err error) (*Response, error) {
-	return &Response{
+	resp := &Response{
 		Success: false,
 		Error:   err.Error(),
-	}, nil
+		Code:    errorStatusCode(err),
+	}
+
+	var detailed interface{ ErrorDetails() interface{} }
+	if errors.As(err, &detailed) {
+		resp.Details = detailed.ErrorDetails()
+	}
+
+	return resp, nil
+}
+
+// errorStatusCode returns the HTTP status code associated with an error,
+// or zero if there is none
+func errorStatusCode(err error) int {
+	var coded interface{ StatusCode() int }
+	if errors.As(err, &coded) {
+		return coded.StatusCode()
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		return int(apiStatus.Status().Code)
+	}
+
+	return 0
 }
 
 // ParseCommand parses a JSON string into a Command
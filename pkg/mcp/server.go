@@ -0,0 +1,404 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	// ServerName is reported to clients during initialize
+	ServerName = "k8s-mcp-server"
+	// ServerVersion is reported to clients during initialize
+	ServerVersion = "0.1.0"
+
+	// LatestProtocolVersion is the newest MCP revision this server speaks
+	LatestProtocolVersion = "2025-06-18"
+)
+
+// supportedProtocolVersions lists the MCP revisions this server can speak
+var supportedProtocolVersions = []string{
+	LatestProtocolVersion,
+	"2025-03-26",
+	"2024-11-05",
+}
+
+// MCP method names
+const (
+	MethodInitialize  = "initialize"
+	MethodInitialized = "notifications/initialized"
+	MethodPing        = "ping"
+	MethodToolsList   = "tools/list"
+	MethodToolsCall   = "tools/call"
+	MethodCancelled   = "notifications/cancelled"
+	MethodSetLogLevel = "logging/setLevel"
+)
+
+// Implementation identifies an MCP client or server
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// InitializeParams are sent by the client to start the handshake
+type InitializeParams struct {
+	ProtocolVersion string          `json:"protocolVersion"`
+	Capabilities    json.RawMessage `json:"capabilities,omitempty"`
+	ClientInfo      Implementation  `json:"clientInfo"`
+}
+
+// InitializeResult is returned to the client to complete the handshake
+type InitializeResult struct {
+	ProtocolVersion string             `json:"protocolVersion"`
+	Capabilities    ServerCapabilities `json:"capabilities"`
+	ServerInfo      Implementation     `json:"serverInfo"`
+	Instructions    string             `json:"instructions,omitempty"`
+}
+
+// ServerCapabilities advertises the features supported by this server
+type ServerCapabilities struct {
+	Tools   *ToolsCapability `json:"tools,omitempty"`
+	Logging *struct{}        `json:"logging,omitempty"`
+}
+
+// ToolsCapability describes the server's support for tools
+type ToolsCapability struct {
+	ListChanged bool `json:"listChanged"`
+}
+
+// ListToolsResult is returned for tools/list
+type ListToolsResult struct {
+	Tools []Tool `json:"tools"`
+}
+
+// CallToolParams are sent by the client for tools/call
+type CallToolParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Meta      *RequestMeta    `json:"_meta,omitempty"`
+}
+
+// RequestMeta carries protocol-level metadata attached to a request
+type RequestMeta struct {
+	ProgressToken json.RawMessage `json:"progressToken,omitempty"`
+}
+
+// SetLevelParams are sent by the client with logging/setLevel
+type SetLevelParams struct {
+	Level string `json:"level"`
+}
+
+// CancelledParams are sent by the client to abandon an in-flight request
+type CancelledParams struct {
+	RequestID json.RawMessage `json:"requestId"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// CallToolResult is returned for tools/call
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Content is a single block of tool output
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+// Session holds the protocol state negotiated with a single client
+type Session struct {
+	mu              sync.Mutex
+	initialized     bool
+	protocolVersion string
+	clientInfo      Implementation
+	logLevel        string
+	notifier        Notifier
+	inFlight        map[string]context.CancelFunc
+}
+
+// NewSession creates a new, uninitialized session
+func NewSession() *Session {
+	return &Session{
+		inFlight: make(map[string]context.CancelFunc),
+	}
+}
+
+// SetNotifier sets the notifier used for server-initiated messages that are
+// not delivered on a request-specific stream
+func (s *Session) SetNotifier(n Notifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifier = n
+}
+
+// Close cancels every request still in flight for the session
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, cancel := range s.inFlight {
+		cancel()
+		delete(s.inFlight, id)
+	}
+	s.notifier = nil
+}
+
+// track registers an in-flight request so it can be cancelled by the client
+func (s *Session) track(ctx context.Context, id json.RawMessage) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s.mu.Lock()
+	s.inFlight[string(id)] = cancel
+	if s.notifier != nil && notifierFromContext(ctx) == nil {
+		ctx = WithNotifier(ctx, s.notifier)
+	}
+	if s.logLevel != "" {
+		ctx = withLogLevel(ctx, s.logLevel)
+	}
+	s.mu.Unlock()
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.inFlight, string(id))
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel aborts an in-flight request
+func (s *Session) cancel(id json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.inFlight[string(id)]; ok {
+		cancel()
+		delete(s.inFlight, string(id))
+	}
+}
+
+// ProtocolVersion returns the protocol version negotiated for the session
+func (s *Session) ProtocolVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVersion
+}
+
+// ClientInfo returns the implementation details reported by the client
+func (s *Session) ClientInfo() Implementation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientInfo
+}
+
+// Initialized reports whether the initialize request has been handled
+func (s *Session) Initialized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.initialized
+}
+
+// Server speaks the Model Context Protocol on behalf of a Handler. It is
+// transport agnostic: transports feed it raw JSON-RPC messages and send
+// back whatever response it produces.
+type Server struct {
+	handler *Handler
+}
+
+// NewServer creates a new MCP protocol server
+func NewServer(handler *Handler) *Server {
+	return &Server{
+		handler: handler,
+	}
+}
+
+// HandleMessage processes a single JSON-RPC message and returns the response
+// to send back, or nil if the message does not warrant one (notifications
+// and responses). A nil session disables handshake enforcement and
+// cancellation, which is used by stateless transports. Notifications emitted
+// while handling a request go to the notifier attached to ctx, falling back
+// to the session's notifier.
+func (s *Server) HandleMessage(ctx context.Context, session *Session, data []byte) *RPCResponse {
+	msg, err := ParseMessage(data)
+	if err != nil {
+		return newErrorRPCResponse(nil, ErrCodeParseError, err.Error())
+	}
+	if msg.JSONRPC != JSONRPCVersion {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidRequest, fmt.Sprintf("unsupported jsonrpc version: %q", msg.JSONRPC))
+	}
+
+	switch {
+	case msg.IsRequest():
+		if session != nil {
+			var done func()
+			ctx, done = session.track(ctx, msg.ID)
+			defer done()
+		}
+		return s.handleRequest(ctx, session, msg)
+	case msg.IsNotification():
+		s.handleNotification(session, msg)
+		return nil
+	case len(msg.ID) != 0 && (msg.Result != nil || msg.Error != nil):
+		// Responses to server-initiated requests; none are issued yet
+		return nil
+	default:
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidRequest, "message is neither a request nor a notification")
+	}
+}
+
+// handleRequest dispatches a request to the matching method handler
+func (s *Server) handleRequest(ctx context.Context, session *Session, msg *Message) *RPCResponse {
+	if msg.Method != MethodInitialize && msg.Method != MethodPing && session != nil && !session.Initialized() {
+		return newErrorRPCResponse(msg.ID, ErrCodeServerNotInitialized, "server not initialized")
+	}
+
+	switch msg.Method {
+	case MethodInitialize:
+		return s.handleInitialize(session, msg)
+	case MethodPing:
+		return newResultResponse(msg.ID, struct{}{})
+	case MethodToolsList:
+		return newResultResponse(msg.ID, ListToolsResult{Tools: s.handler.Tools()})
+	case MethodToolsCall:
+		return s.handleToolsCall(ctx, msg)
+	case MethodSetLogLevel:
+		return s.handleSetLogLevel(session, msg)
+	default:
+		return newErrorRPCResponse(msg.ID, ErrCodeMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method))
+	}
+}
+
+// handleNotification processes a client notification. The initialized
+// notification only confirms the handshake and unknown notifications are
+// ignored.
+func (s *Server) handleNotification(session *Session, msg *Message) {
+	if msg.Method != MethodCancelled || session == nil {
+		return
+	}
+
+	var params CancelledParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.RequestID) == 0 {
+		return
+	}
+	session.cancel(params.RequestID)
+}
+
+// handleInitialize negotiates the protocol version and capabilities
+func (s *Server) handleInitialize(session *Session, msg *Message) *RPCResponse {
+	var params InitializeParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidParams, fmt.Sprintf("invalid initialize params: %v", err))
+	}
+
+	version := negotiateProtocolVersion(params.ProtocolVersion)
+
+	if session != nil {
+		session.mu.Lock()
+		session.initialized = true
+		session.protocolVersion = version
+		session.clientInfo = params.ClientInfo
+		session.mu.Unlock()
+	}
+
+	return newResultResponse(msg.ID, InitializeResult{
+		ProtocolVersion: version,
+		Capabilities: ServerCapabilities{
+			Tools:   &ToolsCapability{ListChanged: false},
+			Logging: &struct{}{},
+		},
+		ServerInfo: Implementation{
+			Name:    ServerName,
+			Version: ServerVersion,
+		},
+		Instructions: "Use these tools to inspect and manage Kubernetes resources and to retrieve, search and export pod logs.",
+	})
+}
+
+// handleSetLogLevel records the minimum level of log notifications the
+// client wants to receive
+func (s *Server) handleSetLogLevel(session *Session, msg *Message) *RPCResponse {
+	var params SetLevelParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil || logLevelRank(params.Level) < 0 {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidParams, "invalid log level")
+	}
+
+	if session != nil {
+		session.mu.Lock()
+		session.logLevel = params.Level
+		session.mu.Unlock()
+	}
+
+	return newResultResponse(msg.ID, struct{}{})
+}
+
+// IsSupportedProtocolVersion reports whether this server speaks the given
+// MCP protocol revision
+func IsSupportedProtocolVersion(version string) bool {
+	for _, supported := range supportedProtocolVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateProtocolVersion echoes the client's version if supported and
+// otherwise proposes the latest version this server speaks
+func negotiateProtocolVersion(requested string) string {
+	if IsSupportedProtocolVersion(requested) {
+		return requested
+	}
+	return LatestProtocolVersion
+}
+
+// handleToolsCall converts a tools/call request into a Command and runs it
+func (s *Server) handleToolsCall(ctx context.Context, msg *Message) *RPCResponse {
+	var params CallToolParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidParams, fmt.Sprintf("invalid tools/call params: %v", err))
+	}
+
+	def, ok := findTool(params.Name)
+	if !ok {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidParams, fmt.Sprintf("unknown tool: %s", params.Name))
+	}
+
+	cmd, err := commandFromArguments(def, params.Arguments)
+	if err != nil {
+		return newErrorRPCResponse(msg.ID, ErrCodeInvalidParams, err.Error())
+	}
+
+	if params.Meta != nil && len(params.Meta.ProgressToken) != 0 {
+		ctx = withProgressToken(ctx, params.Meta.ProgressToken)
+	}
+
+	// Events streamed by long-running tools are forwarded as notifications
+	ctx = WithEventSink(ctx, &notificationSink{ctx: ctx, logger: params.Name})
+
+	resp, err := s.handler.HandleCommand(ctx, cmd)
+	if err != nil {
+		return newResultResponse(msg.ID, toolError(err))
+	}
+
+	return newResultResponse(msg.ID, toolResult(resp))
+}
+
+// toolResult renders a command response as tool output
+func toolResult(resp *Response) CallToolResult {
+	text, err := json.Marshal(resp)
+	if err != nil {
+		return toolError(fmt.Errorf("failed to marshal response: %v", err))
+	}
+
+	return CallToolResult{
+		Content: []Content{{Type: "text", Text: string(text)}},
+		IsError: !resp.Success,
+	}
+}
+
+// toolError renders an execution failure as tool output
+func toolError(err error) CallToolResult {
+	return CallToolResult{
+		Content: []Content{{Type: "text", Text: err.Error()}},
+		IsError: true,
+	}
+}
@@ -0,0 +1,98 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// maxStdioMessageSize bounds a single newline-delimited message on stdin
+const maxStdioMessageSize = 16 * 1024 * 1024
+
+// stdioWriter serializes messages written to the output stream so that
+// concurrently handled requests never interleave their bytes
+type stdioWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// Notify implements Notifier
+func (w *stdioWriter) Notify(notification *Notification) error {
+	return w.write(notification)
+}
+
+// write encodes a message as a single line of JSON
+func (w *stdioWriter) write(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	return nil
+}
+
+// ServeStdio serves a single MCP session over newline-delimited JSON-RPC
+// messages read from in and written to out. It returns when in is exhausted.
+// Nothing but protocol messages is ever written to out.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	writer := &stdioWriter{out: out}
+	session := NewSession()
+	session.SetNotifier(writer)
+	defer session.Close()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxStdioMessageSize)
+
+	var wg sync.WaitGroup
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		// Copy the line, the scanner reuses its buffer
+		data := make([]byte, len(line))
+		copy(data, line)
+
+		// Requests are handled concurrently so a slow tool call does not
+		// block pings, cancellations or other calls. Everything else,
+		// including initialize, is handled inline so later messages always
+		// observe the negotiated session.
+		if msg, err := ParseMessage(data); err != nil || !msg.IsRequest() || msg.Method == MethodInitialize {
+			s.respond(writer, s.HandleMessage(ctx, session, data))
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.respond(writer, s.HandleMessage(ctx, session, data))
+		}()
+	}
+
+	wg.Wait()
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading from stdin: %v", err)
+	}
+	return nil
+}
+
+// respond writes a response if there is one
+func (s *Server) respond(writer *stdioWriter, resp *RPCResponse) {
+	if resp == nil {
+		return
+	}
+	if err := writer.write(resp); err != nil {
+		log.Printf("Failed to send response: %v", err)
+	}
+}
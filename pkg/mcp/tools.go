@@ -0,0 +1,326 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mayukhsarkar/k8s-mcp-server/pkg/logs"
+)
+
+// Tool describes a command exposed to MCP clients through tools/list
+type Tool struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	InputSchema *Schema `json:"inputSchema"`
+}
+
+// Schema is the subset of JSON Schema used to describe tool arguments
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// toolDefinition binds a CommandType to the schema advertised for it
+type toolDefinition struct {
+	command     CommandType
+	description string
+	properties  map[string]*Schema
+	required    []string
+	// logTool marks tools whose log options are passed as top-level arguments
+	logTool bool
+}
+
+// Commonly used argument schemas
+var (
+	resourceProperty = &Schema{
+		Type:        "string",
+		Description: "Kubernetes resource type as plural, singular, kind or short name (pods, pod, Pod, po), optionally qualified by group (deployments.apps); custom resources are supported",
+	}
+	nameProperty = &Schema{
+		Type:        "string",
+		Description: "Name of the resource",
+	}
+	namespaceProperty = &Schema{
+		Type:        "string",
+		Description: "Namespace of the resource; ignored for cluster-scoped resources",
+	}
+	dataProperty = &Schema{
+		Type:        "object",
+		Description: "Full Kubernetes manifest of the resource",
+	}
+	dryRunProperty = &Schema{
+		Type:        "boolean",
+		Description: "Preview the change without persisting it: returns the object as the server would store it and, for existing objects, the fields that would change",
+	}
+)
+
+// Arguments of the log tools that can summarize their result
+var (
+	clusterProperty = &Schema{
+		Type:        "boolean",
+		Description: "Return templates of similar lines with their counts, as summarize_logs does, instead of every entry",
+	}
+	maxTemplatesProperty = &Schema{
+		Type:        "integer",
+		Description: "Maximum number of templates to return, most frequent first; defaults to 100",
+		Minimum:     &zero,
+	}
+)
+
+// zero is the lower bound of count arguments
+var zero = 0.0
+
+// logProperties returns the argument schemas shared by all log tools
+func logProperties() map[string]*Schema {
+	return map[string]*Schema{
+		"namespace":       {Type: "string", Description: "Namespace of the pod"},
+		"pod":             {Type: "string", Description: "Name of the pod; alternatively use selector or workload to read from several pods"},
+		"selector":        {Type: "string", Description: "Label selector choosing the pods to read from, e.g. app=web"},
+		"workload":        {Type: "string", Description: "Workload whose pods to read from, as kind/name: deployment/web, statefulset/db, daemonset/agent, replicaset/web-5d4f, job/migrate"},
+		"container":       {Type: "string", Description: "Container name; required for multi-container pods"},
+		"since":           {Type: "string", Description: "Only return logs newer than a relative duration (e.g. 10m) or an RFC3339 timestamp"},
+		"tail":            {Type: "integer", Description: "Number of lines from the end of the log to return", Minimum: &zero},
+		"pattern":         {Type: "string", Description: "Regular expression that log messages must match"},
+		"log_level":       {Type: "string", Description: "Only return entries with this log level", Enum: []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}},
+		"query":           {Type: "string", Description: "Filter on parsed fields, e.g. level>=warn AND status=500 AND msg~\"timeout\" AND NOT path=\"/health\". Operators: = != > >= < <= ~ (regex) !~; combine with AND, OR, NOT and parentheses. Fields: level, msg, pod, container, namespace, timestamp, app_timestamp or any structured field (dots for nesting)"},
+		"multiline":       {Type: "string", Description: "Group multi-line events such as Java, Python and Go stack traces into one entry; defaults to auto", Enum: []string{"auto", "none"}},
+		"multiline_start": {Type: "string", Description: "Regular expression matching the first line of every event; other lines are appended to the previous event. Overrides multiline"},
+		"parser":          {Type: "string", Description: "How to extract message, level, timestamp and fields from each line; auto detects JSON, logfmt, klog and common library formats", Enum: logs.ParserNames()},
+	}
+}
+
+// toolDefinitions lists every command exposed as an MCP tool
+var toolDefinitions = []toolDefinition{
+	{
+		command:     ListCommand,
+		description: "List Kubernetes resources of a given type, optionally within a namespace. Large lists can be paged with limit and the continue token returned in the response.",
+		properties: map[string]*Schema{
+			"resource":       resourceProperty,
+			"namespace":      namespaceProperty,
+			"label_selector": {Type: "string", Description: "Label selector, e.g. app=web,tier!=cache"},
+			"field_selector": {Type: "string", Description: "Field selector, e.g. status.phase=Running"},
+			"limit":          {Type: "integer", Description: "Maximum number of items to return", Minimum: &zero},
+			"continue":       {Type: "string", Description: "Continue token from a previous page"},
+		},
+		required: []string{"resource"},
+	},
+	{
+		command:     GetCommand,
+		description: "Get a single Kubernetes resource by type and name",
+		properties: map[string]*Schema{
+			"resource":  resourceProperty,
+			"name":      nameProperty,
+			"namespace": namespaceProperty,
+		},
+		required: []string{"resource", "name"},
+	},
+	{
+		command:     CreateCommand,
+		description: "Create a Kubernetes resource from a manifest",
+		properties: map[string]*Schema{
+			"resource":  resourceProperty,
+			"namespace": namespaceProperty,
+			"data":      dataProperty,
+			"dry_run":   dryRunProperty,
+		},
+		required: []string{"resource", "data"},
+	},
+	{
+		command:     UpdateCommand,
+		description: "Replace an existing Kubernetes resource with a full manifest; the manifest must carry the current resourceVersion",
+		properties: map[string]*Schema{
+			"resource":  resourceProperty,
+			"name":      nameProperty,
+			"namespace": namespaceProperty,
+			"data":      dataProperty,
+			"dry_run":   dryRunProperty,
+		},
+		required: []string{"resource", "data"},
+	},
+	{
+		command:     PatchCommand,
+		description: "Patch fields of an existing Kubernetes resource, e.g. to change a replica count or image",
+		properties: map[string]*Schema{
+			"resource":   resourceProperty,
+			"name":       nameProperty,
+			"namespace":  namespaceProperty,
+			"data":       {Description: "Patch document: an array of operations for json patches, an object otherwise"},
+			"patch_type": {Type: "string", Description: "Patch format; defaults to merge", Enum: []string{"json", "merge", "strategic"}},
+			"dry_run":    dryRunProperty,
+		},
+		required: []string{"resource", "name", "data"},
+	},
+	{
+		command:     ApplyCommand,
+		description: "Create or update a Kubernetes resource with server-side apply",
+		properties: map[string]*Schema{
+			"resource":      resourceProperty,
+			"namespace":     namespaceProperty,
+			"data":          dataProperty,
+			"field_manager": {Type: "string", Description: "Field manager recorded as owner of the applied fields; defaults to " + DefaultFieldManager},
+			"force":         {Type: "boolean", Description: "Take ownership of fields owned by other field managers instead of failing with a conflict"},
+			"dry_run":       dryRunProperty,
+		},
+		required: []string{"resource", "data"},
+	},
+	{
+		command:     DeleteCommand,
+		description: "Delete a Kubernetes resource by type and name. Deleting objects in protected namespaces or of protected types needs a confirmation token: run the delete with dry_run first and pass the token it returns as confirmation_token.",
+		properties: map[string]*Schema{
+			"resource":           resourceProperty,
+			"name":               nameProperty,
+			"namespace":          namespaceProperty,
+			"dry_run":            {Type: "boolean", Description: "Validate the delete without performing it; returns a confirmation token for protected objects"},
+			"confirmation_token": {Type: "string", Description: "Token returned by a dry run, required to delete protected objects"},
+		},
+		required: []string{"resource", "name"},
+	},
+	{
+		command:     WatchCommand,
+		description: "Watch Kubernetes resources and report ADDED, MODIFIED and DELETED events, e.g. to follow a rollout or wait for a pod to become ready. Events are streamed as notifications and returned when the watch ends.",
+		properties: map[string]*Schema{
+			"resource":         resourceProperty,
+			"name":             {Type: "string", Description: "Only watch the resource with this name"},
+			"namespace":        namespaceProperty,
+			"label_selector":   {Type: "string", Description: "Label selector, e.g. app=web"},
+			"field_selector":   {Type: "string", Description: "Field selector, e.g. status.phase=Running"},
+			"resource_version": {Type: "string", Description: "Only report changes after this resourceVersion; by default existing resources are reported as ADDED first"},
+			"timeout_seconds":  {Type: "integer", Description: "How long to watch; defaults to 60, at most 3600", Minimum: &zero},
+			"max_events":       {Type: "integer", Description: "Stop after this many events", Minimum: &zero},
+		},
+		required: []string{"resource"},
+	},
+	{
+		command:     LogsCommand,
+		description: "Retrieve logs from a pod, or from all pods of a workload or label selector merged in timestamp order, optionally filtered by pattern and level. With follow set, new entries are streamed as notifications until max_duration elapses.",
+		properties: withProperties(logProperties(), map[string]*Schema{
+			"follow":        {Type: "boolean", Description: "Keep streaming new log entries, like kubectl logs -f"},
+			"cluster":       clusterProperty,
+			"max_templates": maxTemplatesProperty,
+			"max_duration":  {Type: "string", Description: "How long to follow, e.g. 30s or 5m; defaults to 5m, at most 1h"},
+		}),
+		required: []string{"namespace"},
+		logTool:  true,
+	},
+	{
+		command:     SearchLogsCommand,
+		description: "Search the logs of a pod, workload or label selector for lines matching a regular expression pattern and/or a field query. With before, after or context set, matches are returned in hunks with their surrounding entries, as grep -C does.",
+		properties: withProperties(logProperties(), map[string]*Schema{
+			"before":        {Type: "integer", Description: "Number of entries to show before each match, like grep -B", Minimum: &zero},
+			"after":         {Type: "integer", Description: "Number of entries to show after each match, like grep -A", Minimum: &zero},
+			"context":       {Type: "integer", Description: "Number of entries to show before and after each match, like grep -C", Minimum: &zero},
+			"cluster":       clusterProperty,
+			"max_templates": maxTemplatesProperty,
+		}),
+		required: []string{"namespace"},
+		logTool:  true,
+	},
+	{
+		command:     LogStatsCommand,
+		description: "Summarize the logs of a pod, workload or label selector before reading them: counts by level and by pod/container, a histogram over time and the first and last timestamps. Accepts the same filters as logs.",
+		properties: withProperties(logProperties(), map[string]*Schema{
+			"bucket": {Type: "string", Description: "Width of the histogram buckets, e.g. 1m or 5m; defaults to 1m. Wider buckets are used when the logs span more than 1000 buckets"},
+		}),
+		required: []string{"namespace"},
+		logTool:  true,
+	},
+	{
+		command:     SummarizeLogsCommand,
+		description: "Collapse the logs of a pod, workload or label selector into templates of similar lines, with variable parts such as IDs and numbers masked. Each template comes with its occurrence count, first and last time seen and example lines. Use it to get an overview of large logs without reading every line. Accepts the same filters as logs.",
+		properties: withProperties(logProperties(), map[string]*Schema{
+			"max_templates": maxTemplatesProperty,
+		}),
+		required: []string{"namespace"},
+		logTool:  true,
+	},
+	{
+		command:     ExportLogsCommand,
+		description: "Export the logs of a pod, workload or label selector in json, ndjson, csv, plaintext or logfmt format, as RFC 5424 syslog lines, as an OpenTelemetry OTLP/JSON logs request (otlp) or as a Grafana Loki push request (loki)",
+		properties: withProperties(logProperties(), map[string]*Schema{
+			"format": {Type: "string", Description: "Export format", Enum: logs.ExportFormatNames()},
+		}),
+		required: []string{"namespace", "format"},
+		logTool:  true,
+	},
+	{
+		command:     CanICommand,
+		description: "Check whether the server's authorization policy allows you to run a command, without running it. Returns allowed and the rule that decided, so denied operations can be avoided ahead of time.",
+		properties: map[string]*Schema{
+			"verb":      {Type: "string", Description: "Command to check, e.g. delete or logs, or reveal for the data of Secrets"},
+			"resource":  resourceProperty,
+			"name":      {Type: "string", Description: "Name of the resource, or of the pod for log commands; omit to check access to every object"},
+			"namespace": {Type: "string", Description: "Namespace to check; omit for cluster-scoped resources or every namespace"},
+		},
+		required: []string{"verb"},
+	},
+}
+
+// withProperties merges extra argument schemas into base
+func withProperties(base, extra map[string]*Schema) map[string]*Schema {
+	for name, schema := range extra {
+		base[name] = schema
+	}
+	return base
+}
+
+// tool converts the definition into the wire representation
+func (d toolDefinition) tool() Tool {
+	return Tool{
+		Name:        string(d.command),
+		Description: d.description,
+		InputSchema: &Schema{
+			Type:       "object",
+			Properties: d.properties,
+			Required:   d.required,
+		},
+	}
+}
+
+// findTool looks up the definition for a tool name
+func findTool(name string) (toolDefinition, bool) {
+	for _, def := range toolDefinitions {
+		if string(def.command) == name {
+			return def, true
+		}
+	}
+	return toolDefinition{}, false
+}
+
+// ListTools returns the tools advertised to MCP clients
+func ListTools() []Tool {
+	tools := make([]Tool, 0, len(toolDefinitions))
+	for _, def := range toolDefinitions {
+		tools = append(tools, def.tool())
+	}
+	return tools
+}
+
+// commandFromArguments builds a Command from tools/call arguments
+func commandFromArguments(def toolDefinition, args json.RawMessage) (*Command, error) {
+	cmd := &Command{}
+	if len(args) != 0 && string(args) != "null" {
+		if err := json.Unmarshal(args, cmd); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+	cmd.Type = def.command
+
+	// Log tools take their options as top-level arguments, but a nested
+	// log_options object is accepted as well for parity with Command
+	if def.logTool && cmd.LogOptions == nil {
+		var logOptions LogOptions
+		if len(args) != 0 && string(args) != "null" {
+			if err := json.Unmarshal(args, &logOptions); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %v", err)
+			}
+		}
+		cmd.LogOptions = &logOptions
+	}
+
+	return cmd, nil
+}
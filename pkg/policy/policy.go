@@ -0,0 +1,296 @@
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Effect is the outcome of a rule
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// RevealCommand is the command granting callers the unmasked data of
+// Secrets. Only an allow rule grants it, never the default effect.
+const RevealCommand = "reveal"
+
+// LogResource is the resource log commands are authorized against, as in
+// Kubernetes RBAC
+const LogResource = "pods/log"
+
+// Identities assumed for callers, as in Kubernetes
+const (
+	AnonymousUser        = "system:anonymous"
+	UnauthenticatedGroup = "system:unauthenticated"
+	AuthenticatedGroup   = "system:authenticated"
+)
+
+// Rule grants or denies the commands matching all of its fields. Every
+// field holds glob patterns as understood by path.Match; an omitted field
+// matches anything.
+type Rule struct {
+	Name   string `json:"name"`
+	Effect Effect `json:"effect"`
+	// Users and Groups select the callers the rule applies to: a caller
+	// matches when its username matches Users or one of its groups matches
+	// Groups
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Commands are command types such as get, delete or search_logs
+	Commands []string `json:"commands,omitempty"`
+	// Resources are plural resource types, optionally qualified by group
+	// (deployments.apps); log commands use pods/log
+	Resources  []string `json:"resources,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Names      []string `json:"names,omitempty"`
+}
+
+// Policy is an ordered list of rules. The first rule matching a command
+// decides it; commands matching no rule get the default effect.
+type Policy struct {
+	// Default is the effect when no rule matches; defaults to deny
+	Default Effect `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// LoadFile reads a Policy from a YAML or JSON file
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", path, err)
+	}
+
+	return &p, nil
+}
+
+// Validate checks that every rule is named, has a valid effect and valid
+// patterns
+func (p *Policy) Validate() error {
+	if p.Default != "" && p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("invalid default effect '%s' (expected allow or deny)", p.Default)
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate rule name '%s'", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return fmt.Errorf("rule '%s' has invalid effect '%s' (expected allow or deny)", rule.Name, rule.Effect)
+		}
+
+		for _, patterns := range [][]string{rule.Users, rule.Groups, rule.Commands, rule.Resources, rule.Namespaces, rule.Names} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("rule '%s' has invalid pattern '%s'", rule.Name, pattern)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Attributes describe a command to authorize
+type Attributes struct {
+	User   string
+	Groups []string
+	// Command is the command type, e.g. get
+	Command string
+	// Resource is the plural resource type and Group its API group, empty
+	// for the core group
+	Resource string
+	Group    string
+	// Namespace is empty for cluster-scoped resources. AllNamespaces is
+	// set instead for namespaced resources read across every namespace.
+	Namespace     string
+	AllNamespaces bool
+	// Name is empty for commands that do not name a single object
+	Name string
+}
+
+// Decision is the outcome of evaluating a policy
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule names the rule that decided, empty when the default applied
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Evaluate decides whether the command described by attrs is allowed. A nil
+// Policy allows everything.
+func (p *Policy) Evaluate(attrs Attributes) Decision {
+	if p == nil {
+		return Decision{Allowed: true, Reason: "no policy configured"}
+	}
+
+	for _, rule := range p.Rules {
+		if rule.matches(attrs) {
+			if rule.Effect == Allow {
+				return Decision{Allowed: true, Rule: rule.Name, Reason: fmt.Sprintf("allowed by rule '%s'", rule.Name)}
+			}
+			return Decision{Allowed: false, Rule: rule.Name, Reason: fmt.Sprintf("denied by rule '%s'", rule.Name)}
+		}
+	}
+
+	if p.Default == Allow {
+		return Decision{Allowed: true, Reason: "no rule matched (default allow)"}
+	}
+	return Decision{Allowed: false, Reason: "no rule allows it (default deny)"}
+}
+
+// Reveals reports whether the policy grants the caller described by attrs
+// the unmasked data of the Secrets read by its command. Attributes other
+// than the caller are those of the command; the resource is secrets.
+func (p *Policy) Reveals(attrs Attributes) Decision {
+	attrs.Command = RevealCommand
+	attrs.Resource = "secrets"
+	attrs.Group = ""
+
+	decision := p.Evaluate(attrs)
+	if decision.Allowed && decision.Rule == "" {
+		return Decision{Allowed: false, Reason: "no rule allows it (reveal is never granted by default)"}
+	}
+	return decision
+}
+
+// matches reports whether the rule applies to a command. Rules restricted
+// to namespaces or names only allow commands within them, while denials
+// also apply to commands spanning every namespace or object, as those
+// would include the denied ones.
+func (r *Rule) matches(attrs Attributes) bool {
+	if (len(r.Users) > 0 || len(r.Groups) > 0) &&
+		!matchAny(r.Users, attrs.User) && !matchAnyOf(r.Groups, attrs.Groups) {
+		return false
+	}
+	if len(r.Commands) > 0 && !matchAny(r.Commands, attrs.Command) {
+		return false
+	}
+	if len(r.Resources) > 0 && !r.matchesResource(attrs) {
+		return false
+	}
+
+	if len(r.Namespaces) > 0 {
+		switch {
+		case attrs.AllNamespaces:
+			if r.Effect == Allow && !hasWildcard(r.Namespaces) {
+				return false
+			}
+		case attrs.Namespace == "":
+			return false
+		case !matchAny(r.Namespaces, attrs.Namespace):
+			return false
+		}
+	}
+
+	if len(r.Names) > 0 {
+		if attrs.Name == "" {
+			return r.Effect == Deny
+		}
+		if !matchAny(r.Names, attrs.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesResource reports whether the rule's resources match the plural
+// resource type, alone or qualified by its group
+func (r *Rule) matchesResource(attrs Attributes) bool {
+	if matchAny(r.Resources, attrs.Resource) {
+		return true
+	}
+	return attrs.Group != "" && matchAny(r.Resources, attrs.Resource+"."+attrs.Group)
+}
+
+// matchAny reports whether any pattern matches s. A lone * matches
+// everything, including subresources such as pods/log.
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWildcard reports whether patterns include a lone *
+func hasWildcard(patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyOf reports whether any pattern matches any of values
+func matchAnyOf(patterns, values []string) bool {
+	for _, value := range values {
+		if matchAny(patterns, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeniedError is returned for commands the policy does not allow
+type DeniedError struct {
+	Attributes Attributes
+	Decision   Decision
+}
+
+// Error implements error
+func (e *DeniedError) Error() string {
+	attrs := e.Attributes
+	target := attrs.Resource
+	if attrs.Name != "" {
+		target += " '" + attrs.Name + "'"
+	}
+	switch {
+	case attrs.Namespace != "":
+		target += " in namespace '" + attrs.Namespace + "'"
+	case attrs.AllNamespaces:
+		target += " in all namespaces"
+	}
+
+	if attrs.Resource == "" {
+		return fmt.Sprintf("user '%s' may not run %s: %s", attrs.User, attrs.Command, e.Decision.Reason)
+	}
+	return fmt.Sprintf("user '%s' may not run %s on %s: %s", attrs.User, attrs.Command, target, e.Decision.Reason)
+}
+
+// StatusCode returns 403 Forbidden
+func (e *DeniedError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+// ErrorDetails returns the decision, naming the rule that denied the
+// command
+func (e *DeniedError) ErrorDetails() interface{} {
+	return e.Decision
+}
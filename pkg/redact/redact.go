@@ -0,0 +1,410 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SecretMask replaces the values of Secret data and stringData
+const SecretMask = "[REDACTED]"
+
+// MaskPrefix starts the default replacement of detector matches,
+// [REDACTED:<rule>]
+const MaskPrefix = "[REDACTED:"
+
+// lastAppliedAnnotation holds the previous configuration of objects managed
+// with kubectl apply, including the data of Secrets
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Rule masks the parts of strings matching a regular expression
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Replacement replaces each match and may refer to submatches as $1 or
+	// ${name}; defaults to [REDACTED:<Name>]
+	Replacement string
+	// valid, if set, rejects matches that only look sensitive
+	valid func(match string) bool
+}
+
+// builtinRules are the detectors enabled unless disabled by configuration.
+// They run in order, so rules matching inside larger secrets come last.
+var builtinRules = []Rule{
+	{
+		Name:    "private_key",
+		Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	},
+	{
+		Name:        "bearer_token",
+		Pattern:     regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9\-._~+/]{8,}=*`),
+		Replacement: "$1 [REDACTED:bearer_token]",
+	},
+	{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]+`),
+	},
+	{
+		Name:        "url_credentials",
+		Pattern:     regexp.MustCompile(`(://[^\s:/@]+:)[^\s/@]+@`),
+		Replacement: "${1}[REDACTED:url_credentials]@",
+	},
+	{
+		Name:        "credential",
+		Pattern:     regexp.MustCompile(`(?i)\b((?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret)["']?\s*[:=]\s*["']?)([^\s"',;&]+)`),
+		Replacement: "${1}[REDACTED:credential]",
+		valid: func(match string) bool {
+			return !strings.Contains(match, "[REDACTED")
+		},
+	},
+	{
+		Name:    "aws_access_key",
+		Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	},
+	{
+		Name:    "credit_card",
+		Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid:   luhnValid,
+	},
+}
+
+// sensitiveKey matches the names of fields and environment variables whose
+// values are masked as a whole, such as password, DB_PASSWORD or apiKey
+var sensitiveKey = regexp.MustCompile(`(?i)(?:^|[_\-.])(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credentials?)$|(?:Password|Secret|Token|ApiKey|AccessKey|PrivateKey|Credentials?)$`)
+
+// BuiltinRuleNames returns the names of the built-in detectors
+func BuiltinRuleNames() []string {
+	names := make([]string, 0, len(builtinRules))
+	for _, rule := range builtinRules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+// Config configures a Redactor
+type Config struct {
+	// DisableBuiltin names built-in detectors to turn off, e.g. email
+	DisableBuiltin []string `json:"disable_builtin,omitempty"`
+	// Rules are additional detectors
+	Rules []RuleConfig `json:"rules,omitempty"`
+	// RevealSecrets returns the data of Secrets instead of masking it
+	RevealSecrets bool `json:"reveal_secrets,omitempty"`
+}
+
+// RuleConfig is a user-defined detector
+type RuleConfig struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// LoadConfig reads a Config from a YAML or JSON file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read redaction config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse redaction config %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Redactor masks sensitive values in log entries and Kubernetes objects.
+// A nil Redactor leaves everything unchanged.
+type Redactor struct {
+	rules         []Rule
+	revealSecrets bool
+}
+
+// New creates a Redactor running the enabled built-in detectors followed by
+// the configured rules
+func New(cfg Config) (*Redactor, error) {
+	disabled := make(map[string]bool)
+	for _, name := range cfg.DisableBuiltin {
+		disabled[name] = true
+	}
+	for name := range disabled {
+		if !isBuiltin(name) {
+			return nil, fmt.Errorf("unknown built-in redaction rule '%s' (expected one of %s)", name, strings.Join(BuiltinRuleNames(), ", "))
+		}
+	}
+
+	r := &Redactor{revealSecrets: cfg.RevealSecrets}
+	for _, rule := range builtinRules {
+		if !disabled[rule.Name] {
+			r.rules = append(r.rules, rule)
+		}
+	}
+
+	for i, rc := range cfg.Rules {
+		if rc.Name == "" {
+			return nil, fmt.Errorf("redaction rule %d has no name", i+1)
+		}
+		pattern, err := regexp.Compile(rc.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for redaction rule '%s': %v", rc.Name, err)
+		}
+		r.rules = append(r.rules, Rule{Name: rc.Name, Pattern: pattern, Replacement: rc.Replacement})
+	}
+
+	return r, nil
+}
+
+// isBuiltin reports whether name is a built-in detector
+func isBuiltin(name string) bool {
+	for _, rule := range builtinRules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns s with every sensitive value masked, adding the number of
+// values masked to the Counter in ctx
+func (r *Redactor) String(ctx context.Context, s string) string {
+	if r == nil || disabled(ctx) {
+		return s
+	}
+
+	masked, n := r.redact(s)
+	record(ctx, n)
+	return masked
+}
+
+// redact applies every rule to s and returns the result and the number of
+// values masked
+func (r *Redactor) redact(s string) (string, int) {
+	total := 0
+	for _, rule := range r.rules {
+		var n int
+		s, n = rule.apply(s)
+		total += n
+	}
+	return s, total
+}
+
+// apply masks the matches of the rule in s
+func (rule *Rule) apply(s string) (string, int) {
+	matches := rule.Pattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, 0
+	}
+
+	replacement := rule.Replacement
+	if replacement == "" {
+		replacement = MaskPrefix + rule.Name + "]"
+	}
+
+	var b strings.Builder
+	last, n := 0, 0
+	for _, m := range matches {
+		if rule.valid != nil && !rule.valid(s[m[0]:m[1]]) {
+			continue
+		}
+		b.WriteString(s[last:m[0]])
+		b.Write(rule.Pattern.ExpandString(nil, replacement, s, m))
+		last = m[1]
+		n++
+	}
+	b.WriteString(s[last:])
+
+	return b.String(), n
+}
+
+// Fields masks the string values of structured log fields in place. Values
+// of sensitive keys such as password are masked as a whole.
+func (r *Redactor) Fields(ctx context.Context, fields map[string]interface{}) {
+	if r == nil || disabled(ctx) {
+		return
+	}
+	record(ctx, r.redactMap(fields))
+}
+
+// Object masks sensitive values of a Kubernetes object in place. The data
+// and stringData of Secrets are masked unless secrets are revealed by the
+// configuration or for the caller in ctx; every
+// other string is passed through the detectors, and the values of sensitive
+// keys and environment variables are masked as a whole.
+func (r *Redactor) Object(ctx context.Context, object map[string]interface{}) {
+	if r == nil || object == nil || disabled(ctx) {
+		return
+	}
+
+	n := 0
+	if isSecret(object) {
+		if r.revealSecrets || secretsRevealed(ctx) {
+			return
+		}
+		for _, field := range []string{"data", "stringData"} {
+			if values, ok := object[field].(map[string]interface{}); ok {
+				for key := range values {
+					values[key] = SecretMask
+					n++
+				}
+			}
+		}
+		// The last applied configuration repeats the data
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+				if _, ok := annotations[lastAppliedAnnotation]; ok {
+					annotations[lastAppliedAnnotation] = SecretMask
+					n++
+				}
+			}
+		}
+	}
+
+	record(ctx, n+r.redactMap(object))
+}
+
+// isSecret reports whether an object is a core Secret
+func isSecret(object map[string]interface{}) bool {
+	return object["kind"] == "Secret" && object["apiVersion"] == "v1"
+}
+
+// redactMap masks the strings of a map in place and returns the number of
+// values masked
+func (r *Redactor) redactMap(values map[string]interface{}) int {
+	n := 0
+
+	// Environment variables and similar name/value pairs
+	if name, ok := values["name"].(string); ok && sensitiveKey.MatchString(name) {
+		if value, ok := values["value"].(string); ok && value != "" && value != SecretMask {
+			values["value"] = SecretMask
+			n++
+		}
+	}
+
+	for key, value := range values {
+		if s, ok := value.(string); ok && sensitiveKey.MatchString(key) {
+			if s != "" && s != SecretMask {
+				values[key] = SecretMask
+				n++
+			}
+			continue
+		}
+		var masked int
+		values[key], masked = r.redactValue(value)
+		n += masked
+	}
+	return n
+}
+
+// redactValue masks the strings of a decoded JSON value and returns the
+// result and the number of values masked
+func (r *Redactor) redactValue(value interface{}) (interface{}, int) {
+	switch v := value.(type) {
+	case string:
+		return r.redact(v)
+	case map[string]interface{}:
+		return v, r.redactMap(v)
+	case []interface{}:
+		n := 0
+		for i := range v {
+			var masked int
+			v[i], masked = r.redactValue(v[i])
+			n += masked
+		}
+		return v, n
+	default:
+		return value, 0
+	}
+}
+
+// luhnValid reports whether the digits of a candidate card number pass the
+// Luhn checksum
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// Counter counts the values redacted while handling a request. It is safe
+// for concurrent use.
+type Counter struct {
+	n atomic.Int64
+}
+
+// Count returns the number of values redacted so far
+func (c *Counter) Count() int {
+	return int(c.n.Load())
+}
+
+// counterKey is the context key of the Counter
+type counterKey struct{}
+
+// WithCounter returns a context that counts the values redacted under it
+func WithCounter(ctx context.Context) (context.Context, *Counter) {
+	c := &Counter{}
+	return context.WithValue(ctx, counterKey{}, c), c
+}
+
+// record adds n to the Counter in ctx, if any
+func record(ctx context.Context, n int) {
+	if n == 0 {
+		return
+	}
+	if c, ok := ctx.Value(counterKey{}).(*Counter); ok {
+		c.n.Add(int64(n))
+	}
+}
+
+// revealKey is the context key marking callers allowed to read Secret data
+type revealKey struct{}
+
+// WithSecretsRevealed returns a context under which the data of Secrets is
+// returned unmasked, for callers explicitly allowed to read it. Other
+// sensitive values are still masked.
+func WithSecretsRevealed(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revealKey{}, true)
+}
+
+// secretsRevealed reports whether ctx reveals the data of Secrets
+func secretsRevealed(ctx context.Context) bool {
+	revealed, _ := ctx.Value(revealKey{}).(bool)
+	return revealed
+}
+
+// disabledKey is the context key turning redaction off
+type disabledKey struct{}
+
+// WithoutRedaction returns a context under which nothing is redacted, for
+// values that are compared before being masked. The caller must redact them
+// before returning them.
+func WithoutRedaction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, disabledKey{}, true)
+}
+
+// disabled reports whether ctx turns redaction off
+func disabled(ctx context.Context) bool {
+	off, _ := ctx.Value(disabledKey{}).(bool)
+	return off
+}